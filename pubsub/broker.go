@@ -0,0 +1,330 @@
+package pubsub
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yanun0323/errors"
+)
+
+const (
+	_topicSeparator = "."
+	_wildcardOne    = "*"
+	_wildcardRest   = ">"
+)
+
+var (
+	// ErrInvalidTopic represents the error of publishing to an empty topic or a topic containing wildcards.
+	ErrInvalidTopic = errors.New("invalid topic")
+
+	// ErrBrokerClosed represents the error of publishing to a closed broker.
+	ErrBrokerClosed = errors.New("broker closed")
+)
+
+// Event is a message published to a topic of Broker.
+type Event[T any] struct {
+	Topic   string
+	Payload T
+}
+
+// BrokerOption defines settings for NewBroker.
+type BrokerOption struct {
+	// Retain keeps the last message of every topic, and delivers the retained messages matching
+	// the pattern to a new subscriber immediately.
+	Retain bool
+}
+
+// Broker routes the messages published to topics to the subscribers of matching patterns.
+//
+// Topics are dot separated segments, e.g. "trades.btc". A pattern matches topics segment by segment,
+// "*" matches exactly one segment and a trailing ">" matches one or more segments, e.g. "trades.*" matches
+// "trades.btc" but not "trades.btc.usdt", which is matched by "trades.>".
+//
+// Messages of a topic are delivered in the publishing order.
+type Broker[T any] struct {
+	option BrokerOption
+
+	// deliverMu serializes the deliveries to keep the publishing order, mu guards the subscribers and retained messages.
+	deliverMu sync.Mutex
+	mu        sync.RWMutex
+	subs      map[SubscriberID]*brokerSubscription[T]
+	nextID    SubscriberID
+	retained  map[string]Event[T]
+	dropped   atomic.Uint64
+
+	close chan struct{}
+	end   atomic.Bool
+}
+
+type brokerSubscription[T any] struct {
+	*subscription[Event[T]]
+	pattern []string
+}
+
+// NewBroker creates a broker, Broker[any] shares topics of different types with Topic.
+func NewBroker[T any](opts ...BrokerOption) *Broker[T] {
+	option := BrokerOption{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+
+	return &Broker[T]{
+		option:   option,
+		subs:     make(map[SubscriberID]*brokerSubscription[T]),
+		retained: make(map[string]Event[T]),
+		close:    make(chan struct{}),
+	}
+}
+
+// Publish delivers msg to the subscribers whose pattern matches topic.
+//
+// It is safe to be invoked concurrently. With DeliveryBlock subscriptions, it blocks until the slow subscribers have room.
+func (b *Broker[T]) Publish(topic string, msg T) error {
+	segments := splitTopic(topic)
+	for _, s := range segments {
+		if s == "" || s == _wildcardOne || s == _wildcardRest {
+			return errors.Wrapf(ErrInvalidTopic, "publish to (%s)", topic)
+		}
+	}
+
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+
+	b.mu.Lock()
+	if b.end.Load() {
+		b.mu.Unlock()
+		return errors.Wrapf(ErrBrokerClosed, "publish to (%s)", topic)
+	}
+
+	e := Event[T]{Topic: topic, Payload: msg}
+	if b.option.Retain {
+		b.retained[topic] = e
+	}
+
+	var matched []*brokerSubscription[T]
+	for _, sub := range b.subs {
+		if matchTopic(sub.pattern, segments) {
+			matched = append(matched, sub)
+		}
+	}
+	b.mu.Unlock()
+
+	// deliver outside mu, so DeliveryBlock never keeps subscribing, unsubscribing and reading waiting
+	for _, sub := range matched {
+		sub.push(b.close, e)
+	}
+
+	return nil
+}
+
+// Subscribe subscribes the messages of the topics matching pattern.
+//
+// With BrokerOption.Retain, the retained messages matching pattern are queued before the later published ones,
+// and Publish waits until they are queued.
+// The optional SubscribeOption defines the queue and delivery settings of the subscription.
+func (b *Broker[T]) Subscribe(ctx context.Context, pattern string, sub Subscriber[Event[T]], opts ...SubscribeOption[Event[T]]) (*Subscription, error) {
+	return b.SubscribeHandler(ctx, pattern, sub.handler(), opts...)
+}
+
+// SubscribeHandler subscribes the messages of the topics matching pattern with an error-returning handler, see Subscribe.
+//
+// The failed messages are retried and dead-lettered with SubscribeOption.Retry and SubscribeOption.DeadLetter.
+func (b *Broker[T]) SubscribeHandler(ctx context.Context, pattern string, handler Handler[Event[T]], opts ...SubscribeOption[Event[T]]) (*Subscription, error) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	option := SubscribeOption[Event[T]]{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+
+	// the retained messages are queued under deliverMu, so they are always ahead of the later published ones,
+	// while mu is released for DeliveryBlock waiting for the subscriber to consume them
+	if b.option.Retain {
+		b.deliverMu.Lock()
+		defer b.deliverMu.Unlock()
+	}
+
+	b.mu.Lock()
+	if b.end.Load() {
+		b.mu.Unlock()
+		return nil, errors.Wrapf(ErrBrokerClosed, "subscribe (%s)", pattern)
+	}
+
+	id := b.nextID
+	b.nextID++
+
+	remove := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+
+	s := &brokerSubscription[T]{
+		subscription: newSubscription(ctx, id, handler, option, &b.dropped, remove, nil, nil),
+		pattern:      segments,
+	}
+	b.subs[id] = s
+
+	var retained []Event[T]
+	for topic, e := range b.retained {
+		if matchTopic(segments, splitTopic(topic)) {
+			retained = append(retained, e)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, e := range retained {
+		s.push(b.close, e)
+	}
+
+	return s.handle, nil
+}
+
+// Retained returns the retained message of topic.
+func (b *Broker[T]) Retained(topic string) (T, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	e, ok := b.retained[topic]
+	return e.Payload, ok
+}
+
+// Len returns the subscribers number of the broker
+func (b *Broker[T]) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return len(b.subs)
+}
+
+// Dropped returns the number of messages dropped by the delivery modes of all subscriptions.
+func (b *Broker[T]) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close closes the broker, the subscribers stop after consuming the queued messages.
+func (b *Broker[T]) Close() {
+	if b.end.Swap(true) {
+		return
+	}
+
+	// closing first releases the deliveries blocked by DeliveryBlock
+	close(b.close)
+
+	b.deliverMu.Lock()
+	defer b.deliverMu.Unlock()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the subscribing goroutines drain the queued messages before removing themselves
+	for _, sub := range b.subs {
+		close(sub.ch)
+	}
+}
+
+func parsePattern(pattern string) ([]string, error) {
+	segments := splitTopic(pattern)
+	for i, s := range segments {
+		if s == "" || (s == _wildcardRest && i != len(segments)-1) {
+			return nil, errors.Wrapf(ErrInvalidTopic, "parse pattern (%s)", pattern)
+		}
+	}
+
+	return segments, nil
+}
+
+func splitTopic(topic string) []string {
+	return strings.Split(topic, _topicSeparator)
+}
+
+func matchTopic(pattern, topic []string) bool {
+	for i, p := range pattern {
+		if p == _wildcardRest {
+			return len(topic) > i
+		}
+
+		if i >= len(topic) {
+			return false
+		}
+
+		if p != _wildcardOne && p != topic[i] {
+			return false
+		}
+	}
+
+	return len(pattern) == len(topic)
+}
+
+// Topic is a topic carrying messages of T, it shares a Broker[any] with the topics of other types.
+type Topic[T any] string
+
+// PublishTopic publishes msg to the typed topic of b.
+func PublishTopic[T any](b *Broker[any], topic Topic[T], msg T) error {
+	return b.Publish(string(topic), msg)
+}
+
+// SubscribeTopic subscribes the messages of T published to the topics matching the typed pattern.
+//
+// Messages of other types published to the matching topics are ignored.
+func SubscribeTopic[T any](ctx context.Context, b *Broker[any], pattern Topic[T], sub Subscriber[Event[T]], opts ...SubscribeOption[Event[T]]) (*Subscription, error) {
+	return SubscribeTopicHandler(ctx, b, pattern, sub.handler(), opts...)
+}
+
+// SubscribeTopicHandler subscribes the messages of T published to the topics matching the typed pattern
+// with an error-returning handler, see SubscribeTopic.
+//
+// The failed messages are retried and dead-lettered with SubscribeOption.Retry and SubscribeOption.DeadLetter.
+func SubscribeTopicHandler[T any](ctx context.Context, b *Broker[any], pattern Topic[T], handler Handler[Event[T]], opts ...SubscribeOption[Event[T]]) (*Subscription, error) {
+	option := SubscribeOption[Event[T]]{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+
+	return b.SubscribeHandler(ctx, string(pattern), func(e Event[any]) error {
+		return handler(typedEvent[T](e))
+	}, untypedOption(option))
+}
+
+func typedEvent[T any](e Event[any]) Event[T] {
+	payload, _ := e.Payload.(T)
+	return Event[T]{Topic: e.Topic, Payload: payload}
+}
+
+// untypedOption converts the option of a typed subscription, the messages of other types are filtered out by Where.
+func untypedOption[T any](option SubscribeOption[Event[T]]) SubscribeOption[Event[any]] {
+	untyped := SubscribeOption[Event[any]]{
+		Cap:      option.Cap,
+		Delivery: option.Delivery,
+		Retry:    option.Retry,
+		Where: func(e Event[any]) bool {
+			if _, ok := e.Payload.(T); !ok {
+				return false
+			}
+
+			return option.Where == nil || option.Where(typedEvent[T](e))
+		},
+	}
+	if option.OnDrop != nil {
+		untyped.OnDrop = func(e Event[any], delivery Delivery) {
+			option.OnDrop(typedEvent[T](e), delivery)
+		}
+	}
+	if option.OnPanic != nil {
+		untyped.OnPanic = func(e Event[any], err *PanicError) {
+			option.OnPanic(typedEvent[T](e), err)
+		}
+	}
+	if option.DeadLetter != nil {
+		untyped.DeadLetter = func(e Event[any], err error) {
+			option.DeadLetter(typedEvent[T](e), err)
+		}
+	}
+
+	return untyped
+}
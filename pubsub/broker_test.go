@@ -0,0 +1,220 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestMatchTopic(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{pattern: "trades.btc", topic: "trades.btc", match: true},
+		{pattern: "trades.btc", topic: "trades.eth", match: false},
+		{pattern: "trades.*", topic: "trades.btc", match: true},
+		{pattern: "trades.*", topic: "trades", match: false},
+		{pattern: "trades.*", topic: "trades.btc.usdt", match: false},
+		{pattern: "*.btc", topic: "trades.btc", match: true},
+		{pattern: "trades.>", topic: "trades.btc.usdt", match: true},
+		{pattern: "trades.>", topic: "trades", match: false},
+	}
+
+	for _, tc := range testCases {
+		pattern, err := parsePattern(tc.pattern)
+		tester.RequireNoError(t, err)
+		if matchTopic(pattern, splitTopic(tc.topic)) != tc.match {
+			t.Fatalf("pattern (%s) topic (%s) should match: %v", tc.pattern, tc.topic, tc.match)
+		}
+	}
+
+	_, err := parsePattern("trades.>.btc")
+	tester.RequireTrue(t, errors.Is(err, ErrInvalidTopic))
+}
+
+func TestBroker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := NewBroker[int](BrokerOption{Retain: true})
+	defer broker.Close()
+
+	tester.RequireNoError(t, broker.Publish("trades.btc", 1))
+	tester.RequireTrue(t, errors.Is(broker.Publish("trades.*", 1), ErrInvalidTopic))
+
+	received := make(chan Event[int], 10)
+	subscription, err := broker.Subscribe(ctx, "trades.*", func(e Event[int]) {
+		received <- e
+	})
+	tester.RequireNoError(t, err)
+
+	e := receive(t, received)
+	tester.RequireEqual(t, "trades.btc", e.Topic)
+	tester.RequireEqual(t, 1, e.Payload)
+
+	tester.RequireNoError(t, broker.Publish("orders.btc", 2))
+	tester.RequireNoError(t, broker.Publish("trades.eth", 3))
+	e = receive(t, received)
+	tester.RequireEqual(t, "trades.eth", e.Topic)
+	tester.RequireEqual(t, 3, e.Payload)
+
+	retained, ok := broker.Retained("orders.btc")
+	tester.RequireTrue(t, ok)
+	tester.RequireEqual(t, 2, retained)
+
+	subscription.Unsubscribe()
+	tester.RequireEqual(t, 0, broker.Len())
+
+	broker.Close()
+	tester.RequireTrue(t, errors.Is(broker.Publish("trades.btc", 4), ErrBrokerClosed))
+}
+
+func TestBrokerBlockOutsideLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := NewBroker[int]()
+	defer broker.Close()
+
+	gate := make(chan struct{})
+	defer close(gate)
+	_, err := broker.Subscribe(ctx, "slow", func(Event[int]) { <-gate }, SubscribeOption[Event[int]]{Cap: 1, Delivery: DeliveryBlock})
+	tester.RequireNoError(t, err)
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			_ = broker.Publish("slow", i)
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		subscription, err := broker.Subscribe(ctx, "fast", func(Event[int]) {})
+		if err == nil {
+			subscription.Unsubscribe()
+		}
+		_ = broker.Len()
+	}()
+
+	receive(t, done)
+}
+
+func TestBrokerRetainedBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := NewBroker[int](BrokerOption{Retain: true})
+	defer broker.Close()
+
+	for i := 1; i <= 5; i++ {
+		tester.RequireNoError(t, broker.Publish(fmt.Sprintf("retained.%d", i), i))
+	}
+
+	gate := make(chan struct{})
+	received := make(chan int, 10)
+	subscribed := make(chan error, 1)
+	go func() {
+		_, err := broker.Subscribe(ctx, "retained.*", func(e Event[int]) {
+			<-gate
+			received <- e.Payload
+		}, SubscribeOption[Event[int]]{Cap: 1, Delivery: DeliveryBlock})
+		subscribed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = broker.Len()
+		_, _ = broker.Retained("retained.1")
+	}()
+	receive(t, done)
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		_ = broker.Publish("retained.6", 6)
+	}()
+
+	close(gate)
+	tester.RequireNoError(t, receive(t, subscribed))
+	receive(t, published)
+
+	var got []int
+	for range 6 {
+		got = append(got, receive(t, received))
+	}
+	tester.RequireEqual(t, 6, got[5])
+}
+
+func TestTypedTopic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type trade struct {
+		Price float64
+	}
+
+	var (
+		trades = Topic[trade]("trades.btc")
+		counts = Topic[int]("trades.count")
+	)
+
+	broker := NewBroker[any]()
+	defer broker.Close()
+
+	received := make(chan Event[trade], 10)
+	_, err := SubscribeTopic(ctx, broker, Topic[trade]("trades.*"), func(e Event[trade]) {
+		received <- e
+	})
+	tester.RequireNoError(t, err)
+
+	tester.RequireNoError(t, PublishTopic(broker, counts, 1))
+	tester.RequireNoError(t, PublishTopic(broker, trades, trade{Price: 100}))
+
+	e := receive(t, received)
+	tester.RequireEqual(t, "trades.btc", e.Topic)
+	tester.RequireEqual(t, 100.0, e.Payload.Price)
+}
+
+func TestTypedTopicHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	broker := NewBroker[any]()
+	defer broker.Close()
+
+	var (
+		attempts = 0
+		dead     = make(chan Event[int], 10)
+		panics   = make(chan Event[int], 10)
+	)
+	_, err := SubscribeTopicHandler(ctx, broker, Topic[int]("counts"), func(e Event[int]) error {
+		if e.Payload == 1 {
+			panic("boom")
+		}
+		attempts++
+		return errors.New("failed")
+	}, SubscribeOption[Event[int]]{
+		Retry:      RetryOption{Attempts: 2},
+		OnPanic:    func(e Event[int], _ *PanicError) { panics <- e },
+		DeadLetter: func(e Event[int], _ error) { dead <- e },
+	})
+	tester.RequireNoError(t, err)
+
+	tester.RequireNoError(t, broker.Publish("counts", 1))
+	tester.RequireNoError(t, broker.Publish("counts", 2))
+
+	tester.RequireEqual(t, 1, receive(t, panics).Payload)
+	tester.RequireEqual(t, 1, receive(t, dead).Payload)
+	tester.RequireEqual(t, 2, receive(t, dead).Payload)
+	tester.RequireEqual(t, 2, attempts)
+}
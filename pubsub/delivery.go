@@ -0,0 +1,218 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/yanun0323/pkg/channel"
+	"github.com/yanun0323/pkg/sys"
+)
+
+// Delivery defines how a subscription handles a message when its queue is full.
+type Delivery int
+
+const (
+	// DeliveryDrop drops the incoming message, i.e. at-most-once. It is the default mode.
+	DeliveryDrop Delivery = iota
+	// DeliveryBlock blocks the producing until the queue has room, so a slow subscriber slows down all subscribers.
+	DeliveryBlock
+	// DeliveryDropOldest drops the oldest queued message to make room for the incoming one, like a ring buffer.
+	DeliveryDropOldest
+	// DeliveryLatest keeps only the latest unconsumed message, the older one is dropped.
+	DeliveryLatest
+)
+
+func (d Delivery) String() string {
+	switch d {
+	case DeliveryDrop:
+		return "Drop"
+	case DeliveryBlock:
+		return "Block"
+	case DeliveryDropOldest:
+		return "DropOldest"
+	case DeliveryLatest:
+		return "Latest"
+	default:
+		return fmt.Sprintf("Unknown(%d)", d)
+	}
+}
+
+// SubscribeOption defines the queue and delivery settings of a subscription.
+type SubscribeOption[T any] struct {
+	// Cap is the queue capacity of the subscription.
+	//
+	// Zero means using DefaultSubscriberMessageCap. DeliveryLatest always uses capacity 1.
+	Cap int
+	// Delivery is the mode applied when the queue is full.
+	Delivery Delivery
+	// Where filters the messages before queueing, nil means all messages.
+	//
+	// It is evaluated in the producing goroutine, so it must not block.
+	Where func(T) bool
+	// OnDrop is invoked with every message dropped by the delivery mode.
+	//
+	// It is invoked in the producing goroutine, so it must not block.
+	OnDrop func(msg T, delivery Delivery)
+	// OnPanic is invoked with every panic recovered from the subscriber, the subscription keeps running after it.
+	//
+	// Nil means logging the panic and its stack with the logger of the subscribing context.
+	OnPanic func(msg T, err *PanicError)
+	// Retry defines the retry of the messages failed by returning an error or panicking.
+	Retry RetryOption
+	// DeadLetter is invoked with the message which still fails after all attempts, and the last error.
+	//
+	// Nil means discarding the failed message.
+	DeadLetter func(msg T, err error)
+}
+
+func normalizeSubscribeOption[T any](option SubscribeOption[T]) SubscribeOption[T] {
+	if option.Cap <= 0 {
+		option.Cap = DefaultSubscriberMessageCap
+	}
+	if option.Delivery == DeliveryLatest {
+		option.Cap = 1
+	}
+
+	return option
+}
+
+// Subscription is the handle of a subscription.
+type Subscription struct {
+	id          SubscriberID
+	dropped     atomic.Uint64
+	failed      atomic.Uint64
+	unsubscribe func()
+}
+
+// ID returns the id of the subscription.
+func (s *Subscription) ID() SubscriberID {
+	return s.id
+}
+
+// Dropped returns the number of messages dropped by the delivery mode.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Failed returns the number of messages which still fail after all attempts.
+func (s *Subscription) Failed() uint64 {
+	return s.failed.Load()
+}
+
+// Unsubscribe stops the subscription, the queued messages are discarded.
+func (s *Subscription) Unsubscribe() {
+	if s.unsubscribe != nil {
+		s.unsubscribe()
+	}
+}
+
+// subscription is the queue of a subscription, and it is shared by Publisher and Broker.
+type subscription[T any] struct {
+	ch     chan T
+	option SubscribeOption[T]
+	handle *Subscription
+	total  *atomic.Uint64
+	done   <-chan struct{}
+}
+
+// newSubscription creates a subscription and starts the subscribing goroutine, which runs handler with the queued
+// messages until ctx is done or the queue is closed.
+//
+// remove removes the subscription from its owner when unsubscribing or the goroutine stops. before is invoked
+// in the goroutine before consuming the queue, with run handling a message by the recovery, retry and dead-letter
+// of the subscription, and onEnd is invoked after the goroutine stops. The owner must hold its lock until the subscription is registered.
+func newSubscription[T any](ctx context.Context, id SubscriberID, handler Handler[T], option SubscribeOption[T], total *atomic.Uint64, remove func(), before func(ctx context.Context, run func(Handler[T], T)), onEnd func()) *subscription[T] {
+	option = normalizeSubscribeOption(option)
+	ctx, cancel := context.WithCancel(ctx)
+
+	s := &subscription[T]{
+		ch:     make(chan T, option.Cap),
+		option: option,
+		total:  total,
+		done:   ctx.Done(),
+		handle: &Subscription{
+			id: id,
+			unsubscribe: func() {
+				// cancel first to release the producing goroutine blocked by DeliveryBlock
+				cancel()
+				remove()
+			},
+		},
+	}
+
+	go func() {
+		defer func() {
+			remove()
+			if onEnd != nil {
+				onEnd()
+			}
+		}()
+
+		if before != nil {
+			before(ctx, func(handler Handler[T], msg T) {
+				s.run(ctx, handler, msg)
+			})
+		}
+
+		for {
+			select {
+			case <-sys.Shutdown():
+				return
+			case <-ctx.Done():
+				return
+			case msg, ok := <-s.ch:
+				if !ok {
+					return
+				}
+
+				s.run(ctx, handler, msg)
+			}
+		}
+	}()
+
+	return s
+}
+
+// push queues msg with the delivery mode, it is only invoked by the producing goroutine.
+//
+// DeliveryBlock gives up when done is closed.
+func (s *subscription[T]) push(done <-chan struct{}, msg T) {
+	if s.option.Where != nil && !s.option.Where(msg) {
+		return
+	}
+
+	if channel.TryPush(s.ch, msg) {
+		return
+	}
+
+	switch s.option.Delivery {
+	case DeliveryBlock:
+		select {
+		case s.ch <- msg:
+		case <-s.done:
+			s.drop(msg)
+		case <-done:
+			s.drop(msg)
+		}
+	case DeliveryDropOldest, DeliveryLatest:
+		if old, ok := channel.TryReceive(s.ch); ok {
+			s.drop(old)
+		}
+
+		// the subscribing goroutine only takes messages away, so it never fails with a single producer
+		if !channel.TryPush(s.ch, msg) {
+			s.drop(msg)
+		}
+	default:
+		s.drop(msg)
+	}
+}
+
+func (s *subscription[T]) drop(msg T) {
+	s.handle.dropped.Add(1)
+	s.total.Add(1)
+	if s.option.OnDrop != nil {
+		s.option.OnDrop(msg, s.option.Delivery)
+	}
+}
@@ -0,0 +1,286 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/storage"
+	"github.com/yanun0323/pkg/sys"
+)
+
+var (
+	DefaultRetentionInterval = time.Minute
+	DefaultReplayBatch       = 1000
+)
+
+var (
+	// ErrNotDurable represents the error of replaying a publisher without durable log.
+	ErrNotDurable = errors.New("publisher not durable")
+)
+
+// DurableOption defines the durable log of NewDurablePublisher.
+type DurableOption[T any] struct {
+	// Key extracts the compaction key of the message, only the latest record of every key is kept after compaction.
+	//
+	// Nil means never compacting.
+	Key func(T) string
+	// MaxAge is the maximum age of the records.
+	//
+	// Zero means no limit.
+	MaxAge time.Duration
+	// MaxCount is the maximum number of the records, the oldest records are deleted first.
+	//
+	// Zero means no limit.
+	MaxCount int
+	// RetentionInterval is the interval of applying MaxAge, MaxCount and compaction.
+	//
+	// Zero means using DefaultRetentionInterval.
+	RetentionInterval time.Duration
+	// ReplayBatch is the number of records read at a time when replaying.
+	//
+	// Zero means using DefaultReplayBatch.
+	ReplayBatch int
+	// OnError is invoked with the errors of appending, replaying and retention.
+	OnError func(error)
+}
+
+func normalizeDurableOption[T any](option DurableOption[T]) DurableOption[T] {
+	if option.RetentionInterval <= 0 {
+		option.RetentionInterval = DefaultRetentionInterval
+	}
+	if option.ReplayBatch <= 0 {
+		option.ReplayBatch = DefaultReplayBatch
+	}
+	if option.OnError == nil {
+		option.OnError = func(error) {}
+	}
+
+	return option
+}
+
+type durable[T any] struct {
+	log    storage.Log[T]
+	option DurableOption[T]
+	subs   map[SubscriberID]*subscription[storage.Record[T]]
+}
+
+// NewDurablePublisher creates a publisher which appends every message to log before delivering it,
+// so subscribers can replay the messages from an offset with SubscribeFrom, e.g. after restarting.
+//
+// The log is not closed by the publisher.
+func NewDurablePublisher[P Producer[T], T any](producer P, log storage.Log[T], opts ...DurableOption[T]) *Publisher[P, T] {
+	option := DurableOption[T]{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+
+	pub := NewPublisher[P, T](producer)
+	pub.durable = &durable[T]{
+		log:    log,
+		option: normalizeDurableOption(option),
+		subs:   make(map[SubscriberID]*subscription[storage.Record[T]]),
+	}
+
+	return pub
+}
+
+// Log returns the durable log of the publisher, nil if it is not durable.
+func (pub *Publisher[P, T]) Log() storage.Log[T] {
+	if pub.durable == nil {
+		return nil
+	}
+
+	return pub.durable.log
+}
+
+// SubscribeFrom replays the records whose offset is greater than or equal to offset, then subscribes the
+// later published records without gaps or duplicates. Zero offset replays from the oldest record.
+//
+// The later published records are queued while replaying. Delivery other than DeliveryBlock is treated as
+// DeliveryDropOldest, so the latest record always stays in the queue, and the records dropped from the queue
+// or missed by a failed replay are read back from the log before delivering it. SubscribeOption.Retry and
+// SubscribeOption.DeadLetter apply to every record, a dead-lettered record is not delivered again.
+//
+// Resume from the offset of the last consumed record plus one. Records deleted by retention are skipped.
+func (pub *Publisher[P, T]) SubscribeFrom(ctx context.Context, offset int64, sub Subscriber[storage.Record[T]], opts ...SubscribeOption[storage.Record[T]]) (*Subscription, error) {
+	return pub.SubscribeFromHandler(ctx, offset, sub.handler(), opts...)
+}
+
+// SubscribeFromHandler replays and subscribes the records from offset with an error-returning handler, see SubscribeFrom.
+//
+// The failed records are retried and dead-lettered with SubscribeOption.Retry and SubscribeOption.DeadLetter.
+func (pub *Publisher[P, T]) SubscribeFromHandler(ctx context.Context, offset int64, handler Handler[storage.Record[T]], opts ...SubscribeOption[storage.Record[T]]) (*Subscription, error) {
+	d := pub.durable
+	if d == nil {
+		return nil, errors.Wrap(ErrNotDurable, "subscribe from offset")
+	}
+
+	option := SubscribeOption[storage.Record[T]]{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+	if option.Delivery != DeliveryBlock {
+		option.Delivery = DeliveryDropOldest
+	}
+
+	// the records up to boundary are replayed from the log, it is read outside subsMu to keep consumeMessage going
+	_, boundary, err := d.log.Offsets(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get log offsets")
+	}
+
+	pub.subsMu.Lock()
+	defer pub.subsMu.Unlock()
+
+	id := pub.subsNextID
+	pub.subsNextID++
+
+	remove := func() {
+		pub.subsMu.Lock()
+		defer pub.subsMu.Unlock()
+		delete(d.subs, id)
+	}
+
+	// the following are only accessed by the subscribing goroutine, next is the offset of the next record to deliver
+	var (
+		next      = offset
+		replayCtx context.Context
+		run       func(Handler[storage.Record[T]], storage.Record[T])
+	)
+
+	deliver := func(record storage.Record[T]) {
+		run(handler, record)
+		next = record.Offset + 1
+	}
+
+	replay := func(ctx context.Context, r func(Handler[storage.Record[T]], storage.Record[T])) {
+		replayCtx, run = ctx, r
+		d.replay(ctx, offset, boundary, deliver)
+
+		// the records appended after reading boundary and before registering are neither replayed nor queued,
+		// taking subsMu waits for the registering, so the offsets read afterwards cover them
+		pub.subsMu.RLock()
+		pub.subsMu.RUnlock()
+
+		_, last, err := d.log.Offsets(ctx)
+		if err != nil {
+			d.option.OnError(errors.Wrap(err, "get log offsets"))
+			return
+		}
+		d.replay(ctx, next, last, deliver)
+	}
+
+	s := newSubscription(ctx, id, func(record storage.Record[T]) error {
+		// skip the records delivered by replaying
+		if record.Offset < next {
+			return nil
+		}
+
+		// read back the records dropped by the delivery mode or missed by a failed replay
+		if record.Offset > next {
+			d.replay(replayCtx, next, record.Offset-1, deliver)
+		}
+
+		deliver(record)
+		return nil
+	}, option, &pub.dropped, remove, replay, nil)
+
+	if pub.end.Load() {
+		close(s.ch)
+	} else {
+		d.subs[id] = s
+	}
+
+	return s.handle, nil
+}
+
+// replay runs sub with the records from next to boundary.
+func (d *durable[T]) replay(ctx context.Context, next, boundary int64, sub Subscriber[storage.Record[T]]) {
+	for next <= boundary {
+		records, err := d.log.Read(ctx, next, d.option.ReplayBatch)
+		if err != nil {
+			d.option.OnError(errors.Wrapf(err, "replay from offset (%d)", next))
+			return
+		}
+
+		if len(records) == 0 {
+			return
+		}
+
+		for _, record := range records {
+			if record.Offset > boundary {
+				return
+			}
+
+			sub(record)
+			next = record.Offset + 1
+		}
+	}
+}
+
+// append appends msg to the log, returns false if the publisher is not durable or appending fails.
+func (d *durable[T]) append(ctx context.Context, msg T) (storage.Record[T], bool) {
+	if d == nil {
+		return storage.Record[T]{}, false
+	}
+
+	record := storage.Record[T]{Value: msg, CreatedAt: time.Now()}
+	if d.option.Key != nil {
+		record.Key = d.option.Key(msg)
+	}
+
+	offset, err := d.log.Append(ctx, record.Key, msg)
+	if err != nil {
+		d.option.OnError(errors.Wrap(err, "append message"))
+		return record, false
+	}
+
+	record.Offset = offset
+	return record, true
+}
+
+// retain applies the retention and compaction periodically until ctx is done.
+func (d *durable[T]) retain(ctx context.Context) {
+	if d.option.MaxAge <= 0 && d.option.MaxCount <= 0 && d.option.Key == nil {
+		return
+	}
+
+	ticker := time.NewTicker(d.option.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sys.Shutdown():
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.apply(ctx); err != nil {
+				d.option.OnError(err)
+			}
+		}
+	}
+}
+
+func (d *durable[T]) apply(ctx context.Context) error {
+	if d.option.MaxAge > 0 {
+		if err := d.log.DeleteBefore(ctx, time.Now().Add(-d.option.MaxAge)); err != nil {
+			return errors.Wrap(err, "delete expired records")
+		}
+	}
+
+	if d.option.MaxCount > 0 {
+		if err := d.log.Retain(ctx, d.option.MaxCount); err != nil {
+			return errors.Wrap(err, "retain records")
+		}
+	}
+
+	if d.option.Key != nil {
+		if err := d.log.Compact(ctx); err != nil {
+			return errors.Wrap(err, "compact records")
+		}
+	}
+
+	return nil
+}
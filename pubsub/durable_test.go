@@ -0,0 +1,307 @@
+package pubsub
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/storage"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestSubscribeFrom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer log.Close()
+
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log, DurableOption[int]{
+		OnError: func(err error) {
+			t.Errorf("durable, err: %+v", err)
+		},
+	})
+
+	live := make(chan int, 10)
+	pub.Subscribe(ctx, func(n int) {
+		live <- n
+	})
+
+	pub.Start(ctx)
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+		tester.RequireEqual(t, i, receive(t, live))
+	}
+
+	received := make(chan storage.Record[int], 10)
+	_, err = pub.SubscribeFrom(ctx, 2, func(record storage.Record[int]) {
+		received <- record
+	})
+	tester.RequireNoError(t, err)
+
+	producer.ch <- 4
+	for i := 2; i <= 4; i++ {
+		record := receive(t, received)
+		tester.RequireEqual(t, int64(i), record.Offset)
+		tester.RequireEqual(t, i, record.Value)
+	}
+
+	_, err = NewPublisher[*chanProducer[int], int](producer).SubscribeFrom(ctx, 0, func(storage.Record[int]) {})
+	tester.RequireTrue(t, errors.Is(err, ErrNotDurable))
+}
+
+func TestDurableRetention(t *testing.T) {
+	ctx := context.Background()
+
+	log, err := storage.NewLog[string](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer log.Close()
+
+	d := &durable[string]{
+		log: log,
+		option: normalizeDurableOption(DurableOption[string]{
+			Key:      func(s string) string { return s[:1] },
+			MaxCount: 2,
+		}),
+	}
+
+	for _, s := range []string{"a1", "b1", "a2", "c1"} {
+		_, ok := d.append(ctx, s)
+		tester.RequireTrue(t, ok)
+	}
+
+	tester.RequireNoError(t, d.apply(ctx))
+
+	records, err := log.Read(ctx, 0, 10)
+	tester.RequireNoError(t, err)
+	tester.RequireEqual(t, 2, len(records))
+	tester.RequireEqual(t, "a2", records[0].Value)
+	tester.RequireEqual(t, "c1", records[1].Value)
+}
+
+func TestSubscribeFromRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer log.Close()
+
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log)
+	pub.Start(ctx)
+
+	panicked := false
+	received := make(chan int, 10)
+	_, err = pub.SubscribeFrom(ctx, 0, func(record storage.Record[int]) {
+		if record.Value == 2 && !panicked {
+			panicked = true
+			panic("once")
+		}
+		received <- record.Value
+	}, SubscribeOption[storage.Record[int]]{
+		Retry:   RetryOption{Attempts: 2},
+		OnPanic: func(storage.Record[int], *PanicError) {},
+	})
+	tester.RequireNoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+	}
+	for i := 1; i <= 3; i++ {
+		tester.RequireEqual(t, i, receive(t, received))
+	}
+}
+
+func TestSubscribeFromOverflowDuringReplay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer log.Close()
+
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log)
+	pub.Start(ctx)
+
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+	}
+	waitOffset(t, log, 3)
+
+	gate := make(chan struct{})
+	received := make(chan int, 20)
+	_, err = pub.SubscribeFrom(ctx, 0, func(record storage.Record[int]) {
+		if record.Value == 1 {
+			<-gate
+		}
+		received <- record.Value
+	}, SubscribeOption[storage.Record[int]]{Cap: 2})
+	tester.RequireNoError(t, err)
+
+	for i := 4; i <= 10; i++ {
+		producer.ch <- i
+	}
+	waitOffset(t, log, 10)
+	close(gate)
+
+	for i := 1; i <= 10; i++ {
+		tester.RequireEqual(t, i, receive(t, received))
+	}
+	select {
+	case n := <-received:
+		t.Fatalf("unexpected duplicate %d", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeFromReplayFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer inner.Close()
+
+	log := &failingLog[int]{Log: inner}
+	errs := make(chan error, 10)
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log, DurableOption[int]{
+		OnError: func(err error) { errs <- err },
+	})
+	pub.Start(ctx)
+
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+	}
+	waitOffset(t, log, 3)
+
+	log.fail.Store(true)
+	received := make(chan int, 10)
+	_, err = pub.SubscribeFrom(ctx, 0, func(record storage.Record[int]) {
+		received <- record.Value
+	})
+	tester.RequireNoError(t, err)
+
+	receive(t, errs)
+	log.fail.Store(false)
+
+	producer.ch <- 4
+	for i := 1; i <= 4; i++ {
+		tester.RequireEqual(t, i, receive(t, received))
+	}
+}
+
+// failingLog fails reading while fail is set.
+type failingLog[T any] struct {
+	storage.Log[T]
+	fail atomic.Bool
+}
+
+func (l *failingLog[T]) Read(ctx context.Context, from int64, limit int) ([]storage.Record[T], error) {
+	if l.fail.Load() {
+		return nil, errors.New("read failed")
+	}
+
+	return l.Log.Read(ctx, from, limit)
+}
+
+// staleLog runs stale once with the offsets read by Offsets before returning them.
+type staleLog[T any] struct {
+	storage.Log[T]
+	stale func(last int64)
+}
+
+func (l *staleLog[T]) Offsets(ctx context.Context) (int64, int64, error) {
+	first, last, err := l.Log.Offsets(ctx)
+	if l.stale != nil && err == nil {
+		l.stale(last)
+		l.stale = nil
+	}
+
+	return first, last, err
+}
+
+func waitOffset[T any](t *testing.T, log storage.Log[T], offset int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, last, err := log.Offsets(context.Background()); err == nil && last >= offset {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("log does not reach offset %d", offset)
+}
+
+func TestSubscribeFromHandler(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer log.Close()
+
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log)
+	pub.Start(ctx)
+
+	producer.ch <- 1
+	waitOffset(t, log, 1)
+
+	dead := make(chan int64, 10)
+	subscription, err := pub.SubscribeFromHandler(ctx, 0, func(storage.Record[int]) error {
+		return errors.New("failed")
+	}, SubscribeOption[storage.Record[int]]{
+		DeadLetter: func(record storage.Record[int], _ error) { dead <- record.Offset },
+	})
+	tester.RequireNoError(t, err)
+
+	producer.ch <- 2
+	tester.RequireEqual(t, int64(1), receive(t, dead))
+	tester.RequireEqual(t, int64(2), receive(t, dead))
+	tester.RequireEqual(t, uint64(2), subscription.Failed())
+}
+
+func TestSubscribeFromAppendedBeforeRegistering(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inner, err := storage.NewLog[int](filepath.Join(t.TempDir(), "log.db"))
+	tester.RequireNoError(t, err)
+	defer inner.Close()
+
+	log := &staleLog[int]{Log: inner}
+	producer := newChanProducer[int]()
+	pub := NewDurablePublisher[*chanProducer[int], int](producer, log)
+	pub.Start(ctx)
+
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+	}
+	waitOffset(t, inner, 3)
+
+	// the record is appended after the boundary is read and before the subscription is registered
+	log.stale = func(last int64) {
+		producer.ch <- 4
+		waitOffset(t, inner, last+1)
+	}
+
+	received := make(chan int, 10)
+	_, err = pub.SubscribeFrom(ctx, 0, func(record storage.Record[int]) {
+		received <- record.Value
+	})
+	tester.RequireNoError(t, err)
+
+	for i := 1; i <= 4; i++ {
+		tester.RequireEqual(t, i, receive(t, received))
+	}
+}
@@ -0,0 +1,134 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/yanun0323/logs"
+)
+
+var (
+	DefaultMaxRetryBackoff = time.Minute
+)
+
+const (
+	// _maxRetryShift keeps doubling RetryOption.Backoff from overflowing.
+	_maxRetryShift = 32
+)
+
+// Handler is a subscriber which reports the failure of handling a message.
+//
+// A message fails if the handler returns an error or panics, and it is retried with SubscribeOption.Retry
+// before being passed to SubscribeOption.DeadLetter.
+type Handler[T any] func(T) error
+
+func (sub Subscriber[T]) handler() Handler[T] {
+	return func(msg T) error {
+		sub(msg)
+		return nil
+	}
+}
+
+// PanicError represents a panic recovered from a subscriber.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("subscriber panic: %v", e.Value)
+}
+
+// RetryOption defines the retry of the failed messages.
+type RetryOption struct {
+	// Attempts is the maximum number of handling a message, including the first one.
+	//
+	// Zero means never retrying.
+	Attempts int
+	// Backoff is the waiting duration before the first retry, and it is doubled for every later retry.
+	//
+	// Zero means retrying immediately.
+	Backoff time.Duration
+	// MaxBackoff is the maximum waiting duration before a retry.
+	//
+	// Zero means using DefaultMaxRetryBackoff.
+	MaxBackoff time.Duration
+}
+
+// delay returns the waiting duration before the nth retry.
+func (o RetryOption) delay(retry int) time.Duration {
+	if o.Backoff <= 0 {
+		return 0
+	}
+
+	limit := o.MaxBackoff
+	if limit <= 0 {
+		limit = DefaultMaxRetryBackoff
+	}
+
+	shift := min(retry-1, _maxRetryShift)
+	if o.Backoff > limit>>shift {
+		return limit
+	}
+
+	return o.Backoff << shift
+}
+
+// run handles msg with the panic recovery, retry and dead-letter of the subscription.
+//
+// A retry aborted by ctx returns without dead-lettering, the message is discarded with the subscription.
+func (s *subscription[T]) run(ctx context.Context, handler Handler[T], msg T) {
+	attempts := max(s.option.Retry.Attempts, 1)
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i != 0 && !sleep(ctx, s.option.Retry.delay(i)) {
+			return
+		}
+
+		if err = s.call(ctx, handler, msg); err == nil {
+			return
+		}
+	}
+
+	s.handle.failed.Add(1)
+	if s.option.DeadLetter != nil {
+		s.option.DeadLetter(msg, err)
+	}
+}
+
+func (s *subscription[T]) call(ctx context.Context, handler Handler[T], msg T) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			pe := &PanicError{Value: r, Stack: debug.Stack()}
+			if s.option.OnPanic != nil {
+				s.option.OnPanic(msg, pe)
+			} else {
+				logs.Get(ctx).With("subscription", s.handle.id).Errorf("recover subscriber, err: %+v\n%s", pe, pe.Stack)
+			}
+
+			err = pe
+		}
+	}()
+
+	return handler(msg)
+}
+
+// sleep waits for d, returns false if ctx is done before it.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestSubscriberPanic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	var (
+		received = make(chan int, 10)
+		panics   = make(chan *PanicError, 10)
+	)
+	subscription := pub.SubscribeWithOption(ctx, func(n int) {
+		if n == 1 {
+			panic("boom")
+		}
+		received <- n
+	}, SubscribeOption[int]{
+		OnPanic: func(_ int, err *PanicError) {
+			panics <- err
+		},
+	})
+	defer subscription.Unsubscribe()
+
+	pub.Start(ctx)
+	producer.ch <- 1
+	producer.ch <- 2
+
+	err := receive(t, panics)
+	tester.RequireEqual(t, any("boom"), err.Value)
+	tester.RequireTrue(t, len(err.Stack) != 0)
+	tester.RequireEqual(t, 2, receive(t, received))
+	tester.RequireEqual(t, uint64(1), subscription.Failed())
+}
+
+func TestHandlerRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errFailed := errors.New("failed")
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	var (
+		attempts = map[int]int{}
+		received = make(chan int, 10)
+		dead     = make(chan int, 10)
+	)
+	subscription := pub.SubscribeHandler(ctx, func(n int) error {
+		attempts[n]++
+		if n == 1 && attempts[n] < 3 {
+			return errFailed
+		}
+		if n == 2 {
+			return errFailed
+		}
+
+		received <- n
+		return nil
+	}, SubscribeOption[int]{
+		Retry: RetryOption{Attempts: 3, Backoff: time.Millisecond},
+		DeadLetter: func(n int, err error) {
+			tester.RequireTrue(t, errors.Is(err, errFailed))
+			dead <- n
+		},
+	})
+	defer subscription.Unsubscribe()
+
+	pub.Start(ctx)
+	producer.ch <- 1
+	producer.ch <- 2
+	producer.ch <- 3
+
+	tester.RequireEqual(t, 1, receive(t, received))
+	tester.RequireEqual(t, 2, receive(t, dead))
+	tester.RequireEqual(t, 3, receive(t, received))
+	tester.RequireEqual(t, 3, attempts[1])
+	tester.RequireEqual(t, 3, attempts[2])
+	tester.RequireEqual(t, uint64(1), subscription.Failed())
+}
+
+func TestRetryDelay(t *testing.T) {
+	option := RetryOption{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+	tester.RequireEqual(t, time.Second, option.delay(1))
+	tester.RequireEqual(t, 4*time.Second, option.delay(3))
+	tester.RequireEqual(t, 10*time.Second, option.delay(5))
+	tester.RequireEqual(t, 10*time.Second, option.delay(100))
+	tester.RequireEqual(t, DefaultMaxRetryBackoff, RetryOption{Backoff: time.Hour}.delay(1))
+	tester.RequireEqual(t, time.Duration(0), RetryOption{}.delay(100))
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	failed := make(chan struct{})
+	dead := make(chan int, 1)
+	subscription := pub.SubscribeHandler(ctx, func(int) error {
+		close(failed)
+		return errors.New("failed")
+	}, SubscribeOption[int]{
+		Retry:      RetryOption{Attempts: 2, Backoff: time.Minute},
+		DeadLetter: func(n int, _ error) { dead <- n },
+	})
+
+	pub.Start(ctx)
+	producer.ch <- 1
+	receive(t, failed)
+	subscription.Unsubscribe()
+
+	select {
+	case <-dead:
+		t.Fatal("canceled retry is dead-lettered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	tester.RequireEqual(t, uint64(0), subscription.Failed())
+}
@@ -0,0 +1,131 @@
+package pubsub
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Pipe is the Producer of the derived Publisher created by Filter, Map and Batch.
+//
+// It subscribes the upstream Publisher when the derived Publisher starts, and stops producing
+// when the upstream Publisher ends or the context of the derived Publisher is done.
+type Pipe[T any] struct {
+	out     chan T
+	connect func(ctx context.Context, out chan<- T)
+	start   atomic.Bool
+}
+
+func newPipe[T any](connect func(ctx context.Context, out chan<- T)) *Pipe[T] {
+	return &Pipe[T]{
+		out:     make(chan T, DefaultSubscriberMessageCap),
+		connect: connect,
+	}
+}
+
+func (p *Pipe[T]) Start(ctx context.Context) {
+	if p.start.Swap(true) {
+		return
+	}
+
+	p.connect(ctx, p.out)
+}
+
+func (p *Pipe[T]) Produce() <-chan T {
+	return p.out
+}
+
+// emit pushes msg into out, returns false if ctx is done before pushing.
+func emit[T any](ctx context.Context, out chan<- T, msg T) bool {
+	select {
+	case out <- msg:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Filter creates a Publisher of the messages from src matching the predicate.
+//
+// The derived Publisher must be started to subscribe src.
+func Filter[P Producer[T], T any](src *Publisher[P, T], predicate func(T) bool, subscribeCap ...int) *Publisher[*Pipe[T], T] {
+	return NewPublisher[*Pipe[T], T](newPipe(func(ctx context.Context, out chan<- T) {
+		src.subscribe(ctx, func(msg T) error {
+			emit(ctx, out, msg)
+			return nil
+		}, SubscribeOption[T]{Where: predicate}, func() {
+			close(out)
+		})
+	}), subscribeCap...)
+}
+
+// Map creates a Publisher of the messages from src transformed by fn.
+//
+// The derived Publisher must be started to subscribe src.
+func Map[P Producer[T], T, R any](src *Publisher[P, T], fn func(T) R, subscribeCap ...int) *Publisher[*Pipe[R], R] {
+	return NewPublisher[*Pipe[R], R](newPipe(func(ctx context.Context, out chan<- R) {
+		src.subscribe(ctx, func(msg T) error {
+			emit(ctx, out, fn(msg))
+			return nil
+		}, SubscribeOption[T]{}, func() {
+			close(out)
+		})
+	}), subscribeCap...)
+}
+
+// Batch creates a Publisher of the messages from src grouped into slices.
+//
+// A batch is published when it reaches size messages or every interval, whichever comes first.
+// Zero size means batching by interval only, and zero interval means batching by size only.
+// Empty batches are never published, and the remaining messages are published when src ends.
+//
+// The derived Publisher must be started to subscribe src.
+func Batch[P Producer[T], T any](src *Publisher[P, T], size int, interval time.Duration, subscribeCap ...int) *Publisher[*Pipe[[]T], []T] {
+	return NewPublisher[*Pipe[[]T], []T](newPipe(func(ctx context.Context, out chan<- []T) {
+		in := make(chan T, DefaultSubscriberMessageCap)
+		src.subscribe(ctx, func(msg T) error {
+			emit(ctx, in, msg)
+			return nil
+		}, SubscribeOption[T]{}, func() {
+			close(in)
+		})
+
+		go func() {
+			defer close(out)
+
+			var tick <-chan time.Time
+			if interval > 0 {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+
+			var batch []T
+			flush := func() {
+				if len(batch) == 0 {
+					return
+				}
+
+				emit(ctx, out, batch)
+				batch = nil
+			}
+
+			for {
+				select {
+				case msg, ok := <-in:
+					if !ok {
+						flush()
+						return
+					}
+
+					batch = append(batch, msg)
+					if size > 0 && len(batch) >= size {
+						flush()
+					}
+				case <-tick:
+					flush()
+				}
+			}
+		}()
+	}), subscribeCap...)
+}
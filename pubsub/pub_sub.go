@@ -2,13 +2,13 @@ package pubsub
 
 import (
 	"context"
-	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/yanun0323/errors"
 	"github.com/yanun0323/pkg/channel"
+	"github.com/yanun0323/pkg/storage"
 	"github.com/yanun0323/pkg/sys"
 )
 
@@ -30,8 +30,10 @@ type Publisher[P Producer[T], T any] struct {
 	producer P
 
 	subsMu     sync.RWMutex
-	subs       map[SubscriberID]chan T
+	subs       map[SubscriberID]*subscription[T]
 	subsNextID SubscriberID
+	dropped    atomic.Uint64
+	durable    *durable[T]
 
 	stop context.CancelFunc
 
@@ -47,7 +49,7 @@ func NewPublisher[P Producer[T], T any](producer P, subscribeCap ...int) *Publis
 
 	return &Publisher[P, T]{
 		producer: producer,
-		subs:     make(map[SubscriberID]chan T, caps),
+		subs:     make(map[SubscriberID]*subscription[T], caps),
 	}
 }
 
@@ -71,6 +73,9 @@ func (pub *Publisher[P, T]) Start(ctx context.Context) {
 	pub.stop = cancel
 
 	go pub.consumeMessage(ctx)
+	if pub.durable != nil {
+		go pub.durable.retain(ctx)
+	}
 	pub.producer.Start(ctx)
 }
 
@@ -85,70 +90,111 @@ func (pub *Publisher[P, T]) consumeMessage(ctx context.Context) {
 			if !ok {
 				pub.subsMu.Lock()
 				pub.end.Store(true)
+				// the subscribing goroutines drain the queued messages before removing themselves
 				for _, sub := range pub.subs {
-					channel.SafeClose(sub)
+					close(sub.ch)
+				}
+				if pub.durable != nil {
+					for _, sub := range pub.durable.subs {
+						close(sub.ch)
+					}
 				}
 				pub.subsMu.Unlock()
 
 				return
 			}
 
+			record, recorded := pub.durable.append(ctx, msg)
+
+			// deliver outside the lock, so DeliveryBlock never keeps subscribing and unsubscribing waiting
 			pub.subsMu.RLock()
-			for id, sub := range pub.subs {
-				if ok := channel.TryPush(sub, msg); !ok {
-					fmt.Printf("message dropped! %d subscriber channel is full\n", id)
+			subs := make([]*subscription[T], 0, len(pub.subs))
+			for _, sub := range pub.subs {
+				subs = append(subs, sub)
+			}
+			var records []*subscription[storage.Record[T]]
+			if recorded {
+				records = make([]*subscription[storage.Record[T]], 0, len(pub.durable.subs))
+				for _, sub := range pub.durable.subs {
+					records = append(records, sub)
 				}
 			}
 			pub.subsMu.RUnlock()
+
+			for _, sub := range subs {
+				sub.push(ctx.Done(), msg)
+			}
+			for _, sub := range records {
+				sub.push(ctx.Done(), record)
+			}
 		}
 	}
 }
 
 func (pub *Publisher[P, T]) Subscribe(ctx context.Context, sub Subscriber[T], messageCap ...int) (unsubscribe func()) {
-	caps := DefaultSubscriberMessageCap
-	if len(messageCap) != 0 && messageCap[0] > 0 {
-		caps = messageCap[0]
+	return pub.SubscribeWhere(ctx, nil, sub, messageCap...)
+}
+
+// SubscribeWhere subscribes the messages matching the predicate, nil predicate matches all messages.
+//
+// The predicate is evaluated in the producing goroutine before queueing, so it must not block.
+func (pub *Publisher[P, T]) SubscribeWhere(ctx context.Context, predicate func(T) bool, sub Subscriber[T], messageCap ...int) (unsubscribe func()) {
+	option := SubscribeOption[T]{Where: predicate}
+	if len(messageCap) != 0 {
+		option.Cap = messageCap[0]
 	}
 
-	ch := make(chan T, caps)
+	return pub.SubscribeWithOption(ctx, sub, option).Unsubscribe
+}
+
+// SubscribeWithOption subscribes the messages with the queue and delivery settings, and returns the handle of the subscription.
+func (pub *Publisher[P, T]) SubscribeWithOption(ctx context.Context, sub Subscriber[T], option SubscribeOption[T]) *Subscription {
+	return pub.subscribe(ctx, sub.handler(), option, nil)
+}
+
+// SubscribeHandler subscribes the messages with an error-returning handler, and returns the handle of the subscription.
+//
+// The failed messages are retried and dead-lettered with SubscribeOption.Retry and SubscribeOption.DeadLetter.
+func (pub *Publisher[P, T]) SubscribeHandler(ctx context.Context, handler Handler[T], opts ...SubscribeOption[T]) *Subscription {
+	option := SubscribeOption[T]{}
+	if len(opts) != 0 {
+		option = opts[0]
+	}
+
+	return pub.subscribe(ctx, handler, option, nil)
+}
+
+// Dropped returns the number of messages dropped by the delivery modes of all subscriptions.
+func (pub *Publisher[P, T]) Dropped() uint64 {
+	return pub.dropped.Load()
+}
+
+// subscribe runs handler with the queued messages in a goroutine, and invokes onEnd after the goroutine stops.
+func (pub *Publisher[P, T]) subscribe(ctx context.Context, handler Handler[T], option SubscribeOption[T], onEnd func()) *Subscription {
 	pub.subsMu.Lock()
 	defer pub.subsMu.Unlock()
 
 	if pub.end.Load() {
-		return
+		if onEnd != nil {
+			onEnd()
+		}
+
+		return &Subscription{id: -1}
 	}
 
 	id := pub.subsNextID
 	pub.subsNextID++
-	pub.subs[id] = ch
 
-	ctx, cancel := context.WithCancel(ctx)
-	go func() {
-		defer channel.SafeClose(ch)
-
-		for {
-			select {
-			case <-sys.Shutdown():
-				return
-			case <-ctx.Done():
-				return
-			case msg, ok := <-ch:
-				if !ok {
-					return
-				}
-
-				sub(msg)
-			}
-		}
-	}()
-
-	return func() {
+	remove := func() {
 		pub.subsMu.Lock()
 		defer pub.subsMu.Unlock()
 		delete(pub.subs, id)
-
-		cancel()
 	}
+
+	s := newSubscription(ctx, id, handler, option, &pub.dropped, remove, nil, onEnd)
+	pub.subs[id] = s
+
+	return s.handle
 }
 
 func (pub *Publisher[P, T]) SubscribeAndWait(ctx context.Context, send func(context.Context, P) error, isExpected func(context.Context, T) bool, timeout ...time.Duration) error {
@@ -0,0 +1,211 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+type chanProducer[T any] struct {
+	ch chan T
+}
+
+func newChanProducer[T any]() *chanProducer[T] {
+	return &chanProducer[T]{ch: make(chan T, 100)}
+}
+
+func (p *chanProducer[T]) Start(context.Context) {}
+
+func (p *chanProducer[T]) Produce() <-chan T {
+	return p.ch
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	return *new(T)
+}
+
+func TestSubscribeWhere(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	received := make(chan int, 10)
+	unsubscribe := pub.SubscribeWhere(ctx, func(n int) bool { return n%2 == 0 }, func(n int) {
+		received <- n
+	})
+	defer unsubscribe()
+
+	pub.Start(ctx)
+	for i := 1; i <= 4; i++ {
+		producer.ch <- i
+	}
+
+	tester.RequireEqual(t, 2, receive(t, received))
+	tester.RequireEqual(t, 4, receive(t, received))
+}
+
+func TestOperators(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	odd := Filter(pub, func(n int) bool { return n%2 == 1 })
+	doubled := Map(odd, func(n int) int { return n * 2 })
+	batched := Batch(doubled, 3, time.Hour)
+
+	received := make(chan []int, 10)
+	batched.Subscribe(ctx, func(batch []int) {
+		received <- batch
+	})
+
+	pub.Start(ctx)
+	odd.Start(ctx)
+	doubled.Start(ctx)
+	batched.Start(ctx)
+
+	for i := 1; i <= 8; i++ {
+		producer.ch <- i
+	}
+
+	batch := receive(t, received)
+	tester.RequireEqual(t, 3, len(batch))
+	tester.RequireEqual(t, 2, batch[0])
+	tester.RequireEqual(t, 6, batch[1])
+	tester.RequireEqual(t, 10, batch[2])
+
+	close(producer.ch)
+	batch = receive(t, received)
+	tester.RequireEqual(t, 1, len(batch))
+	tester.RequireEqual(t, 14, batch[0])
+}
+
+func TestBatchInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+	batched := Batch(pub, 0, 20*time.Millisecond)
+
+	received := make(chan []int, 10)
+	batched.Subscribe(ctx, func(batch []int) {
+		received <- batch
+	})
+
+	pub.Start(ctx)
+	batched.Start(ctx)
+
+	producer.ch <- 1
+	producer.ch <- 2
+
+	batch := receive(t, received)
+	tester.RequireEqual(t, 2, len(batch))
+}
+
+func TestDelivery(t *testing.T) {
+	testCases := []struct {
+		delivery Delivery
+		received []int
+	}{
+		{delivery: DeliveryDrop, received: []int{1, 2}},
+		{delivery: DeliveryBlock, received: []int{1, 2, 3, 4}},
+		{delivery: DeliveryDropOldest, received: []int{1, 4}},
+		{delivery: DeliveryLatest, received: []int{1, 4}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.delivery.String(), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			producer := newChanProducer[int]()
+			pub := NewPublisher[*chanProducer[int], int](producer)
+
+			var (
+				entered  = make(chan struct{})
+				gate     = make(chan struct{})
+				received = make(chan int, 10)
+				dropped  = make(chan int, 10)
+			)
+			subscription := pub.SubscribeWithOption(ctx, func(n int) {
+				if n == 1 {
+					close(entered)
+					<-gate
+				}
+				received <- n
+			}, SubscribeOption[int]{
+				Cap:      1,
+				Delivery: tc.delivery,
+				OnDrop: func(n int, _ Delivery) {
+					dropped <- n
+				},
+			})
+			defer subscription.Unsubscribe()
+
+			pub.Start(ctx)
+			producer.ch <- 1
+			receive(t, entered)
+			for i := 2; i <= 4; i++ {
+				producer.ch <- i
+			}
+
+			drops := 4 - len(tc.received)
+			for i := 0; i < drops; i++ {
+				receive(t, dropped)
+			}
+			if tc.delivery == DeliveryBlock {
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			close(gate)
+			for _, want := range tc.received {
+				tester.RequireEqual(t, want, receive(t, received))
+			}
+
+			tester.RequireEqual(t, uint64(drops), subscription.Dropped())
+			tester.RequireEqual(t, uint64(drops), pub.Dropped())
+		})
+	}
+}
+
+func TestDeliveryBlockOutsideLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	producer := newChanProducer[int]()
+	pub := NewPublisher[*chanProducer[int], int](producer)
+
+	gate := make(chan struct{})
+	defer close(gate)
+	pub.SubscribeWithOption(ctx, func(int) { <-gate }, SubscribeOption[int]{Cap: 1, Delivery: DeliveryBlock})
+
+	pub.Start(ctx)
+	for i := 1; i <= 3; i++ {
+		producer.ch <- i
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		pub.Subscribe(ctx, func(int) {})()
+		_ = pub.Len()
+	}()
+
+	receive(t, subscribed)
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 // Local represents a local storage for a single type
@@ -40,6 +41,44 @@ type Local[T any] interface {
 	Close() error
 }
 
+// Log represents an append-only local log for a single type
+//
+// Records are identified by positive and increasing offsets, which are never reused even after deletion
+type Log[T any] interface {
+	// Append appends the value with the key, and returns the offset of the record
+	//
+	// The key is used by Compact, empty key means the record is never compacted
+	Append(ctx context.Context, key string, value T) (int64, error)
+
+	// Read retrieves at most limit records whose offset is greater than or equal to from, in offset order
+	Read(ctx context.Context, from int64, limit int) ([]Record[T], error)
+
+	// Offsets returns the first and the last offset of the records
+	//
+	// Returns zeros if the log is empty
+	Offsets(ctx context.Context) (first, last int64, err error)
+
+	// DeleteBefore deletes the records created before the time
+	DeleteBefore(ctx context.Context, t time.Time) error
+
+	// Retain deletes the records except the latest count ones
+	Retain(ctx context.Context, count int) error
+
+	// Compact deletes the records except the latest one of every non-empty key
+	Compact(ctx context.Context) error
+
+	// Close disconnects from the log
+	Close() error
+}
+
+// Record represents a record of Log
+type Record[T any] struct {
+	Offset    int64
+	Key       string
+	Value     T
+	CreatedAt time.Time
+}
+
 type db interface {
 	// Exec(query string, args ...any) (sql.Result, error)
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
@@ -8,14 +8,16 @@ import (
 	"github.com/yanun0323/errors"
 )
 
-func openConnAndCheckType[T any](path string) (*sql.DB, error) {
+func openConnAndCheckType[T any](path string, schemas ...string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", path)
 	if err != nil {
 		return nil, wrapError("create sqlite db, err: %+v", err)
 	}
 
 	db.Exec(_schemaStorageType)
-	db.Exec(_schemaStorage)
+	for _, schema := range schemas {
+		db.Exec(schema)
+	}
 
 	if err := checkStorageType[T](db); err != nil {
 		return nil, wrapError("check storage type, err: %+v", err)
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/gob"
+	"time"
+
+	"github.com/yanun0323/errors"
+)
+
+type log[T any] struct {
+	path string
+	db   *sql.DB
+}
+
+// NewLog creates a new local append-only log
+//
+// The log is stored in a sqlite3 file at the given path
+func NewLog[T any](path string) (Log[T], error) {
+	db, err := openConnAndCheckType[T](path, _schemaLog, _schemaLogKeyIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	// serialize the writes of appending and retention to avoid the busy errors of sqlite
+	db.SetMaxOpenConns(1)
+
+	return &log[T]{
+		path: path,
+		db:   db,
+	}, nil
+}
+
+func (l *log[T]) Append(ctx context.Context, key string, value T) (int64, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(value); err != nil {
+		return 0, wrapError("encode value, err: %+v", err)
+	}
+
+	result, err := l.db.ExecContext(ctx, "INSERT INTO log (key, value, created_at) VALUES (?, ?, ?)", key, buf.Bytes(), time.Now().UnixNano())
+	if err != nil {
+		return 0, wrapError("append value, err: %+v", err)
+	}
+
+	offset, err := result.LastInsertId()
+	if err != nil {
+		return 0, wrapError("get offset, err: %+v", err)
+	}
+
+	return offset, nil
+}
+
+func (l *log[T]) Read(ctx context.Context, from int64, limit int) ([]Record[T], error) {
+	rows, err := l.db.QueryContext(ctx, "SELECT seq, key, value, created_at FROM log WHERE seq >= ? ORDER BY seq LIMIT ?", from, limit)
+	if err != nil {
+		return nil, wrapError("read records, err: %+v", err)
+	}
+	defer rows.Close()
+
+	records := make([]Record[T], 0, limit)
+	for rows.Next() {
+		var (
+			record    Record[T]
+			blobData  []byte
+			createdAt int64
+		)
+
+		if err := rows.Scan(&record.Offset, &record.Key, &blobData, &createdAt); err != nil {
+			return nil, wrapError("scan record, err: %+v", err)
+		}
+
+		buf := bytes.NewBuffer(blobData)
+		dec := gob.NewDecoder(buf)
+		if err := dec.Decode(&record.Value); err != nil {
+			return nil, wrapError("decode value, err: %+v", err)
+		}
+
+		record.CreatedAt = time.Unix(0, createdAt)
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, wrapError("read records, err: %+v", err)
+	}
+
+	return records, nil
+}
+
+func (l *log[T]) Offsets(ctx context.Context) (int64, int64, error) {
+	var first, last int64
+	err := l.db.QueryRowContext(ctx, "SELECT COALESCE(MIN(seq), 0), COALESCE(MAX(seq), 0) FROM log").Scan(&first, &last)
+	if err != nil {
+		return 0, 0, wrapError("get offsets, err: %+v", err)
+	}
+
+	return first, last, nil
+}
+
+func (l *log[T]) DeleteBefore(ctx context.Context, t time.Time) error {
+	_, err := l.db.ExecContext(ctx, "DELETE FROM log WHERE created_at < ?", t.UnixNano())
+	return wrapError("delete records, err: %+v", err)
+}
+
+func (l *log[T]) Retain(ctx context.Context, count int) error {
+	_, err := l.db.ExecContext(ctx, "DELETE FROM log WHERE seq NOT IN (SELECT seq FROM log ORDER BY seq DESC LIMIT ?)", count)
+	return wrapError("retain records, err: %+v", err)
+}
+
+func (l *log[T]) Compact(ctx context.Context) error {
+	_, err := l.db.ExecContext(ctx, "DELETE FROM log WHERE key != '' AND seq NOT IN (SELECT MAX(seq) FROM log WHERE key != '' GROUP BY key)")
+	return wrapError("compact records, err: %+v", err)
+}
+
+func (l *log[T]) Close() error {
+	if err := l.db.Close(); err != nil {
+		if errors.Is(err, sql.ErrConnDone) {
+			return nil
+		}
+
+		return wrapError("close database, err: %+v", err)
+	}
+
+	return nil
+}
@@ -14,5 +14,18 @@ CREATE TABLE IF NOT EXISTS storage (
 	created_at INTEGER NOT NULL DEFAULT 0,
 	updated_at INTEGER NOT NULL DEFAULT 0
 )
+`
+
+	_schemaLog = `
+CREATE TABLE IF NOT EXISTS log (
+	seq INTEGER PRIMARY KEY AUTOINCREMENT,
+	key TEXT NOT NULL DEFAULT '',
+	value BLOB NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL DEFAULT 0
+)
+`
+
+	_schemaLogKeyIndex = `
+CREATE INDEX IF NOT EXISTS log_key ON log (key)
 `
 )
@@ -29,7 +29,7 @@ func (l *storage[T]) driver() db {
 //
 // The storage is stored in a sqlite3 file at the given path
 func New[T any](path string) (Local[T], error) {
-	db, err := openConnAndCheckType[T](path)
+	db, err := openConnAndCheckType[T](path, _schemaStorage)
 	if err != nil {
 		return nil, err
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/yanun0323/errors"
 	"github.com/yanun0323/pkg/tester"
@@ -279,3 +280,76 @@ func TestLocal_Atomic(t *testing.T) {
 		tester.RequireFalse(t, ok)
 	}
 }
+
+func TestLog(t *testing.T) {
+	defer func() {
+		tester.RequireNoError(t, Delete("./test_log.db"))
+	}()
+
+	ctx := context.Background()
+
+	l, err := NewLog[string]("./test_log.db")
+	tester.RequireNoError(t, err)
+
+	{
+		first, last, err := l.Offsets(ctx)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, int64(0), first)
+		tester.RequireEqual(t, int64(0), last)
+	}
+
+	for i, key := range []string{"a", "b", "a", "", "b"} {
+		offset, err := l.Append(ctx, key, key+string(rune('0'+i)))
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, int64(i+1), offset)
+	}
+
+	{
+		records, err := l.Read(ctx, 2, 2)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, 2, len(records))
+		tester.RequireEqual(t, int64(2), records[0].Offset)
+		tester.RequireEqual(t, "b", records[0].Key)
+		tester.RequireEqual(t, "b1", records[0].Value)
+		tester.RequireEqual(t, "a2", records[1].Value)
+	}
+
+	{
+		tester.RequireNoError(t, l.Compact(ctx))
+
+		records, err := l.Read(ctx, 0, 10)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, 3, len(records))
+		tester.RequireEqual(t, "a2", records[0].Value)
+		tester.RequireEqual(t, "3", records[1].Value)
+		tester.RequireEqual(t, "b4", records[2].Value)
+	}
+
+	{
+		tester.RequireNoError(t, l.Retain(ctx, 2))
+
+		first, last, err := l.Offsets(ctx)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, int64(4), first)
+		tester.RequireEqual(t, int64(5), last)
+	}
+
+	{
+		tester.RequireNoError(t, l.DeleteBefore(ctx, time.Now()))
+
+		records, err := l.Read(ctx, 0, 10)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, 0, len(records))
+
+		offset, err := l.Append(ctx, "", "next")
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, int64(6), offset)
+	}
+
+	tester.RequireNoError(t, l.Close())
+
+	{
+		_, err := NewLog[int]("./test_log.db")
+		tester.RequireErrorIs(t, ErrTypeMismatch, err)
+	}
+}
@@ -0,0 +1,51 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestBackoffJitter(t *testing.T) {
+	for _, jitter := range []Jitter{JitterNone, JitterFull, JitterEqual, JitterDecorrelated} {
+		b := newBackoffState(BackoffOption{Min: 10 * time.Millisecond, Max: 80 * time.Millisecond, Jitter: jitter})
+		for i := 0; i < 20; i++ {
+			delay, ok := b.Next()
+			tester.RequireTrue(t, ok)
+			tester.RequireTrue(t, delay >= 0)
+			tester.RequireTrue(t, delay <= 80*time.Millisecond)
+		}
+	}
+}
+
+func TestBackoffMaxAttempts(t *testing.T) {
+	b := newBackoffState(BackoffOption{MaxAttempts: 2})
+
+	_, ok := b.Next()
+	tester.RequireTrue(t, ok)
+	_, ok = b.Next()
+	tester.RequireTrue(t, ok)
+	_, ok = b.Next()
+	tester.RequireFalse(t, ok)
+
+	b.Reset()
+	_, ok = b.Next()
+	tester.RequireTrue(t, ok)
+}
+
+func TestStartGaveUp(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := New(ctx, "ws://127.0.0.1:1", Option{
+		Backoff: BackoffOption{Min: time.Millisecond, MaxAttempts: 2},
+	})
+
+	err := client.Start(ctx)
+	tester.RequireTrue(t, errors.Is(err, ErrReconnectExhausted))
+	tester.RequireTrue(t, errors.Is(client.Err(), ErrReconnectExhausted))
+	tester.RequireEqual(t, StateClosed, client.State())
+}
@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+
+	"github.com/yanun0323/pkg/syncs"
+)
+
+const (
+	// _maxPooledBufferSize is the maximum capacity of a buffer returned into the pool,
+	// larger buffers are left to the garbage collector to avoid pinning memory.
+	_maxPooledBufferSize = 1 << 20
+)
+
+var _bufferPool = syncs.NewPool(func() *buffer { return &buffer{} })
+
+// buffer is a reference counted frame buffer shared by the copies of a Message.
+type buffer struct {
+	bytes.Buffer
+	refs atomic.Int32
+}
+
+func (b *buffer) Reset() {
+	b.Buffer.Reset()
+	b.refs.Store(0)
+}
+
+// readBuffer reads a frame into a pooled buffer holding one reference.
+func readBuffer(r io.Reader) (*buffer, error) {
+	b := _bufferPool.Get()
+	b.refs.Store(1)
+	if _, err := b.ReadFrom(r); err != nil {
+		_bufferPool.Put(b)
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Retain adds a reference to the pooled buffer of the message, Release it when it is no longer used.
+//
+// It is a no-op for messages without pooled buffer, see Option.PooledBuffers.
+func (m Message) Retain() Message {
+	if m.buf != nil {
+		m.buf.refs.Add(1)
+	}
+
+	return m
+}
+
+// Release drops a reference to the pooled buffer of the message.
+//
+// The buffer is reused once all references are released, so Data must not be used after releasing.
+// Messages never released are collected by the garbage collector as usual.
+// It is a no-op for messages without pooled buffer, see Option.PooledBuffers.
+func (m Message) Release() {
+	if m.buf == nil {
+		return
+	}
+
+	if m.buf.refs.Add(-1) == 0 && m.buf.Cap() <= _maxPooledBufferSize {
+		_bufferPool.Put(m.buf)
+	}
+}
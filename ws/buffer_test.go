@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/yanun0323/pkg/sys"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestMessageRelease(t *testing.T) {
+	buf, err := readBuffer(bytes.NewReader([]byte("pooled")))
+	tester.RequireNoError(t, err)
+
+	msg := Message{Type: MessageTypeText, Data: buf.Bytes(), buf: buf}
+	tester.RequireEqual(t, "pooled", string(msg.Data))
+
+	copied := msg.Retain()
+	tester.RequireEqual(t, int32(2), buf.refs.Load())
+
+	msg.Release()
+	tester.RequireEqual(t, "pooled", string(copied.Data))
+	copied.Release()
+	tester.RequireEqual(t, int32(0), buf.refs.Load())
+
+	Message{Data: []byte("not pooled")}.Retain().Release()
+}
+
+func TestPooledBuffers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url, Option{PooledBuffers: true})
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	first, unsubscribeFirst := client.Subscribe()
+	defer unsubscribeFirst()
+
+	second, unsubscribeSecond := client.Subscribe()
+	defer unsubscribeSecond()
+	waitPeers(t, hub, 1)
+
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeBinary, []byte("shared")))
+
+	a := waitMessage(t, first)
+	b := waitMessage(t, second)
+	tester.RequireTrue(t, a.buf != nil && a.buf == b.buf)
+
+	a.Release()
+	tester.RequireEqual(t, "shared", string(b.Data))
+	b.Release()
+}
+
+// readLoop connects a client to a hub, and returns the function reading a broadcast payload.
+func readLoop(t testing.TB, pooled bool, payload []byte) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	hub := NewHub(ctx)
+	t.Cleanup(hub.Close)
+
+	server, url := serveHub(hub)
+	t.Cleanup(server.Close)
+
+	client := New(ctx, url, Option{PooledBuffers: pooled})
+	if err := client.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	messages, unsubscribe := client.Subscribe()
+	t.Cleanup(unsubscribe)
+	waitPeers(t, hub, 1)
+
+	return func() {
+		_ = hub.BroadcastRaw(MessageTypeBinary, payload)
+		msg := <-messages
+		msg.Release()
+	}
+}
+
+func TestPooledBuffersAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("measuring allocations takes seconds")
+	}
+
+	payload := bytes.Repeat([]byte{'x'}, 64<<10)
+	_, plain := sys.MeasureMem(readLoop(t, false, payload))
+	_, pooled := sys.MeasureMem(readLoop(t, true, payload))
+	t.Logf("bytes per message, plain: %d, pooled: %d", plain, pooled)
+
+	tester.RequireTrue(t, pooled < plain)
+}
+
+func BenchmarkReadMessage(b *testing.B) {
+	read := readLoop(b, false, bytes.Repeat([]byte{'x'}, 4<<10))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		read()
+	}
+}
+
+func BenchmarkReadMessagePooled(b *testing.B) {
+	read := readLoop(b, true, bytes.Repeat([]byte{'x'}, 4<<10))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		read()
+	}
+}
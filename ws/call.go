@@ -0,0 +1,205 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/channel"
+)
+
+// Correlation defines how Call stamps the request id and finds the id of responses.
+type Correlation struct {
+	// Marshal encodes the request with the assigned id into a message.
+	//
+	// Nil means marshaling the request as a JSON object with an additional "id" field.
+	Marshal func(id string, req any) (Message, error)
+	// ID extracts the request id from an incoming message.
+	//
+	// Nil means reading the "id" field of the JSON message.
+	ID Router
+}
+
+type callResult struct {
+	msg Message
+	err error
+}
+
+// pendingCall is a call waiting for the response on the connection it was sent on.
+//
+// conn is nil while the request waits in the outbound queue, it is bound when the request is written.
+type pendingCall struct {
+	result chan callResult
+	conn   *dialing
+}
+
+func normalizeCorrelation(c Correlation) Correlation {
+	if c.Marshal == nil {
+		c.Marshal = marshalJSONCall
+	}
+	if c.ID == nil {
+		c.ID = JSONRouter("id")
+	}
+
+	return c
+}
+
+func marshalJSONCall(id string, req any) (Message, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return Message{}, errors.Wrap(err, "marshal request")
+	}
+
+	obj := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return Message{}, errors.Wrap(err, "request is not a json object")
+	}
+
+	obj["id"] = json.RawMessage(id)
+	data, err = json.Marshal(obj)
+	if err != nil {
+		return Message{}, errors.Wrap(err, "marshal request with id")
+	}
+
+	return Message{Type: MessageTypeText, Data: data}, nil
+}
+
+// Call sends a request with an assigned id and waits for the response with the same id.
+//
+// The id is assigned and extracted by Option.Correlation. Pending calls fail with
+// ErrConnectionClose once the connection they were sent on is torn down.
+// With Option.WriteQueue enabled, the request is written by the writer goroutine in order with the other writes.
+//
+// The response holds a reference to its pooled buffer, call Message.Release when it is no longer used.
+//
+// DefaultWaitTimeout is applied when ctx has no deadline.
+func (ws *WebSocket) Call(ctx context.Context, req any) (Message, error) {
+	id := strconv.FormatUint(ws.nextCallID.Add(1), 10)
+	msg, err := ws.option.Correlation.Marshal(id, req)
+	if err != nil {
+		return Message{}, errors.Wrap(err, "marshal call")
+	}
+
+	if ws.IsClose() {
+		return Message{}, errors.Wrap(ErrConnectionClose, "websocket closed")
+	}
+
+	var d *dialing
+	if !ws.option.WriteQueue.Enable {
+		if d = ws.getConn(); d == nil {
+			return Message{}, errors.Wrap(ErrConnectionClose, "nil ws connection")
+		}
+	}
+
+	result := make(chan callResult, 1)
+	ws.callsLock.Lock()
+	ws.calls[id] = pendingCall{result: result, conn: d}
+	ws.callsLock.Unlock()
+
+	defer func() {
+		ws.callsLock.Lock()
+		delete(ws.calls, id)
+		ws.callsLock.Unlock()
+
+		// the response resolved after losing to ctx is never returned
+		if res, ok := channel.TryReceive(result); ok {
+			res.msg.Release()
+		}
+	}()
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultWaitTimeout)
+		defer cancel()
+	}
+
+	if err := ws.limit(ctx, MessageClassRequest); err != nil {
+		return Message{}, errors.Wrapf(err, "send call (%s)", id)
+	}
+
+	if err := ws.sendCall(ctx, id, d, msg); err != nil {
+		return Message{}, errors.Wrapf(err, "send call (%s)", id)
+	}
+
+	select {
+	case <-ctx.Done():
+		return Message{}, errors.Wrapf(ctx.Err(), "wait call (%s)", id)
+	case res := <-result:
+		if res.err != nil {
+			return Message{}, errors.Wrapf(res.err, "wait call (%s)", id)
+		}
+
+		return res.msg, nil
+	}
+}
+
+// sendCall writes the request of the call, through the writer goroutine if the write queue is enabled.
+func (ws *WebSocket) sendCall(ctx context.Context, id string, d *dialing, msg Message) error {
+	if ws.option.WriteQueue.Enable {
+		return ws.send(ctx, &outbound{
+			msg:  msg,
+			done: make(chan error, 1),
+			onWrite: func(d *dialing) {
+				ws.bindCall(id, d)
+			},
+		})
+	}
+
+	if err := ws.limit(ctx, MessageClassWrite); err != nil {
+		return err
+	}
+
+	return d.WriteRaw(msg.Type, msg.Data)
+}
+
+// bindCall binds the pending call to the connection its request is written on.
+func (ws *WebSocket) bindCall(id string, d *dialing) {
+	ws.callsLock.Lock()
+	defer ws.callsLock.Unlock()
+
+	if call, ok := ws.calls[id]; ok {
+		call.conn = d
+		ws.calls[id] = call
+	}
+}
+
+// resolveCall delivers msg to the pending call with the same id, returns true if any call is resolved.
+func (ws *WebSocket) resolveCall(msg Message) bool {
+	ws.callsLock.Lock()
+	defer ws.callsLock.Unlock()
+
+	if len(ws.calls) == 0 {
+		return false
+	}
+
+	id, ok := ws.option.Correlation.ID(msg)
+	if !ok {
+		return false
+	}
+
+	call, ok := ws.calls[id]
+	if !ok {
+		return false
+	}
+
+	delete(ws.calls, id)
+	call.result <- callResult{msg: msg.Retain()}
+
+	return true
+}
+
+// failCalls fails the pending calls sent on conn with err, nil conn fails all pending calls including the queued ones.
+func (ws *WebSocket) failCalls(conn *dialing, err error) {
+	ws.callsLock.Lock()
+	defer ws.callsLock.Unlock()
+
+	for id, call := range ws.calls {
+		if conn != nil && call.conn != conn {
+			continue
+		}
+
+		delete(ws.calls, id)
+		call.result <- callResult{err: err}
+	}
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestCall(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	go func() {
+		for msg := range inbound {
+			var req struct {
+				ID     json.Number `json:"id"`
+				Method string      `json:"method"`
+			}
+			if err := msg.Message.Unmarshal(&req); err != nil {
+				continue
+			}
+
+			_ = msg.Peer.WriteJSON(map[string]any{"id": req.ID, "result": req.Method})
+		}
+	}()
+
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	for _, method := range []string{"a", "b", "c"} {
+		resp, err := client.Call(ctx, map[string]string{"method": method})
+		tester.RequireNoError(t, err)
+
+		var result struct {
+			Result string `json:"result"`
+		}
+		tester.RequireNoError(t, resp.Unmarshal(&result))
+		tester.RequireEqual(t, method, result.Result)
+	}
+
+	client.Close()
+	_, err := client.Call(ctx, map[string]string{"method": "closed"})
+	tester.RequireTrue(t, errors.Is(err, ErrConnectionClose))
+}
+
+func TestFailCallsScopedToConnection(t *testing.T) {
+	ws := New(context.Background(), "ws://localhost")
+	prev, next := &dialing{}, &dialing{}
+	prevResult, nextResult := make(chan callResult, 1), make(chan callResult, 1)
+	ws.calls["prev"] = pendingCall{result: prevResult, conn: prev}
+	ws.calls["next"] = pendingCall{result: nextResult, conn: next}
+
+	ws.failCalls(prev, ErrConnectionClose)
+	tester.RequireEqual(t, 1, len(prevResult))
+	tester.RequireEqual(t, 0, len(nextResult))
+
+	ws.failCalls(nil, ErrConnectionClose)
+	tester.RequireEqual(t, 1, len(nextResult))
+}
+
+func TestCallWriteQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	received := make(chan string, 10)
+	go func() {
+		for msg := range inbound {
+			received <- string(msg.Message.Data)
+
+			var req struct {
+				ID json.Number `json:"id"`
+			}
+			if err := msg.Message.Unmarshal(&req); err == nil {
+				_ = msg.Peer.WriteJSON(map[string]any{"id": req.ID})
+			}
+		}
+	}()
+
+	client := New(ctx, url, Option{WriteQueue: WriteQueueOption{Enable: true}})
+	defer client.Close()
+
+	tester.RequireNoError(t, client.WriteRaw(MessageTypeText, []byte("queued")))
+	done := make(chan error, 1)
+	go func() {
+		resp, err := client.Call(ctx, map[string]string{"method": "a"})
+		resp.Release()
+		done <- err
+	}()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for client.queued.n.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	tester.RequireNoError(t, client.Start(ctx))
+
+	tester.RequireEqual(t, "queued", waitMessage(t, received))
+	tester.RequireEqual(t, `{"id":1,"method":"a"}`, waitMessage(t, received))
+	tester.RequireNoError(t, waitMessage(t, done))
+}
@@ -0,0 +1,230 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/channel"
+)
+
+const (
+	_defaultCloseTimeout = time.Second
+	_defaultDrainTimeout = 5 * time.Second
+	_drainPollInterval   = 10 * time.Millisecond
+)
+
+// Close codes defined in RFC 6455, section 11.7.
+const (
+	CloseNormalClosure   = websocket.CloseNormalClosure
+	CloseGoingAway       = websocket.CloseGoingAway
+	CloseProtocolError   = websocket.CloseProtocolError
+	ClosePolicyViolation = websocket.ClosePolicyViolation
+	CloseInternalError   = websocket.CloseInternalServerErr
+	CloseTryAgainLater   = websocket.CloseTryAgainLater
+)
+
+// CloseError is the close code and reason sent by the peer.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("peer closed (%d)", e.Code)
+	}
+
+	return fmt.Sprintf("peer closed (%d): %s", e.Code, e.Reason)
+}
+
+// CloseOption defines the close frame sent by Shutdown.
+type CloseOption struct {
+	// Code is the close code.
+	//
+	// Zero means CloseNormalClosure.
+	Code int
+	// Reason is the close reason, it is truncated to fit into a control frame.
+	Reason string
+	// DrainTimeout limits the whole draining of Shutdown when ctx has no deadline.
+	//
+	// Zero means using the default timeout 5s.
+	DrainTimeout time.Duration
+}
+
+// DrainError reports what is lost by Shutdown.
+type DrainError struct {
+	// Unsent is the number of queued outbound messages never written.
+	Unsent int
+	// Undelivered is the number of inbound messages left in subscription queues.
+	Undelivered int
+	// Err is the failure of the close handshake, e.g. the peer does not reply before the deadline.
+	Err error
+}
+
+func (e *DrainError) Error() string {
+	var msgs []string
+	if e.Unsent != 0 {
+		msgs = append(msgs, fmt.Sprintf("%d queued messages unsent", e.Unsent))
+	}
+	if e.Undelivered != 0 {
+		msgs = append(msgs, fmt.Sprintf("%d messages undelivered", e.Undelivered))
+	}
+	if e.Err != nil {
+		msgs = append(msgs, e.Err.Error())
+	}
+
+	return "shutdown: " + strings.Join(msgs, ", ")
+}
+
+func (e *DrainError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown gracefully closes the websocket.
+//
+// It is named after http.Server.Shutdown rather than replacing Close with Close(ctx),
+// so Close keeps its signature and remains the immediate close used by defer and Pool.
+//
+// It waits for the outbound queue to be written, sends a close frame, waits for the peer's close frame,
+// and waits for the subscribers to consume the queued messages, until ctx is done.
+// Without a deadline of ctx, the whole draining is limited to CloseOption.DrainTimeout,
+// and waiting for the peer's close frame is limited to 1s.
+// It returns *DrainError describing what is lost, and closes the websocket anyway.
+func (ws *WebSocket) Shutdown(ctx context.Context, opts ...CloseOption) error {
+	option := CloseOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+	if option.Code == 0 {
+		option.Code = CloseNormalClosure
+	}
+	if option.DrainTimeout <= 0 {
+		option.DrainTimeout = _defaultDrainTimeout
+	}
+
+	if ws.IsClose() {
+		return nil
+	}
+
+	handshakeTimeout := time.Duration(0)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, option.DrainTimeout)
+		defer cancel()
+		handshakeTimeout = _defaultCloseTimeout
+	}
+
+	drainErr := &DrainError{}
+	ws.queued.wait(ctx)
+	drainErr.Unsent = int(ws.queued.n.Load())
+
+	ws.draining.Store(true)
+	if d := ws.getConn(); d != nil {
+		drainErr.Err = d.closeHandshake(ctx, handshakeTimeout, option.Code, option.Reason)
+	}
+
+	wait(ctx, func() bool { return ws.fanout.pending() == 0 })
+	drainErr.Undelivered = ws.fanout.pending()
+
+	ws.Close()
+
+	if drainErr.Unsent == 0 && drainErr.Undelivered == 0 && drainErr.Err == nil {
+		return nil
+	}
+
+	return drainErr
+}
+
+// PeerClose returns the close code and reason sent by the peer of the last connection, nil if the peer never closes.
+func (ws *WebSocket) PeerClose() *CloseError {
+	return ws.peerClose.Load()
+}
+
+// pendingCount counts the pending items and signals idle whenever the count drops to zero.
+type pendingCount struct {
+	n    atomic.Int64
+	idle chan struct{}
+}
+
+func (c *pendingCount) add(delta int64) {
+	if c.n.Add(delta) == 0 {
+		channel.TryPush(c.idle, struct{}{})
+	}
+}
+
+// wait waits until the count is zero or ctx is done.
+func (c *pendingCount) wait(ctx context.Context) {
+	for c.n.Load() != 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.idle:
+		}
+	}
+}
+
+// wait polls done until it returns true or ctx is done.
+//
+// It is used for the subscription queues, whose receiving by the subscribers cannot be observed.
+func wait(ctx context.Context, done func() bool) {
+	if done() {
+		return
+	}
+
+	ticker := time.NewTicker(_drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if done() {
+				return
+			}
+		}
+	}
+}
+
+// writeClose sends the close frame once.
+func (c *dialing) writeClose(deadline time.Time, code int, reason string) error {
+	if c.closeSent.Swap(true) {
+		return nil
+	}
+
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+
+	return c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+}
+
+// closeHandshake sends the close frame and waits for the peer's close frame until ctx is done.
+//
+// A positive timeout further limits the waiting.
+func (c *dialing) closeHandshake(ctx context.Context, timeout time.Duration, code int, reason string) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := c.writeClose(deadline, code, reason); err != nil {
+		c.Close()
+		return errors.Wrap(err, "write close frame")
+	}
+
+	select {
+	case <-ctx.Done():
+		c.Close()
+		return errors.Wrap(ctx.Err(), "wait for peer close")
+	case <-c.Done():
+		return nil
+	}
+}
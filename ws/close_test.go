@@ -0,0 +1,128 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+	waitPeers(t, hub, 1)
+
+	peers := hub.Peers()
+	tester.RequireEqual(t, 1, len(peers))
+
+	tester.RequireNoError(t, client.Shutdown(ctx, CloseOption{Code: CloseGoingAway, Reason: "bye"}))
+	tester.RequireTrue(t, client.IsClose())
+
+	select {
+	case <-peers[0].Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("peer is not closed")
+	}
+
+	var ce *CloseError
+	tester.RequireTrue(t, errors.As(peers[0].d.Err(), &ce))
+	tester.RequireEqual(t, CloseGoingAway, ce.Code)
+	tester.RequireEqual(t, "bye", ce.Reason)
+}
+
+func TestShutdownUndelivered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	_, unsubscribe := client.Subscribe()
+	defer unsubscribe()
+	waitPeers(t, hub, 1)
+
+	for range 3 {
+		tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, []byte("pending")))
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for client.fanout.pending() != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shutdownCancel()
+
+	var drainErr *DrainError
+	tester.RequireTrue(t, errors.As(client.Shutdown(shutdownCtx), &drainErr))
+	tester.RequireEqual(t, 3, drainErr.Undelivered)
+	tester.RequireEqual(t, 0, drainErr.Unsent)
+}
+
+func TestPeerClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url)
+	events, unsubscribe := client.Events()
+	defer unsubscribe()
+
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+	waitPeers(t, hub, 1)
+
+	for _, p := range hub.Peers() {
+		p.Close()
+	}
+
+	for {
+		e := waitMessage(t, events)
+		if e.Type != EventDisconnected {
+			continue
+		}
+
+		var ce *CloseError
+		tester.RequireTrue(t, errors.As(e.Err, &ce))
+		tester.RequireEqual(t, CloseNormalClosure, ce.Code)
+		break
+	}
+
+	tester.RequireNotNil(t, client.PeerClose())
+}
+
+func TestShutdownStalledSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	_, unsubscribe := client.Subscribe()
+	defer unsubscribe()
+	waitPeers(t, hub, 1)
+
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, []byte("stalled")))
+	deadline := time.Now().Add(3 * time.Second)
+	for client.fanout.pending() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	var drainErr *DrainError
+	tester.RequireTrue(t, errors.As(client.Shutdown(context.Background(), CloseOption{DrainTimeout: 100 * time.Millisecond}), &drainErr))
+	tester.RequireEqual(t, 1, drainErr.Undelivered)
+	tester.RequireTrue(t, time.Since(start) < time.Second)
+	tester.RequireTrue(t, client.IsClose())
+}
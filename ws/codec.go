@@ -0,0 +1,46 @@
+package ws
+
+import (
+	"encoding"
+	"encoding/json"
+
+	"github.com/yanun0323/errors"
+)
+
+// Codec decodes messages into values for typed subscriptions.
+//
+// Implement it to plug in other encodings, e.g. msgpack.
+type Codec interface {
+	// Name identifies the codec, values decoded by codecs with the same name are shared.
+	Name() string
+	// Unmarshal decodes data into v.
+	Unmarshal(data []byte, v any) error
+}
+
+// JSONCodec decodes messages with encoding/json. It is the default codec.
+type JSONCodec struct{}
+
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+func (JSONCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// BinaryCodec decodes messages with encoding.BinaryUnmarshaler implemented by the value,
+// e.g. protobuf messages wrapped with proto.Unmarshal.
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string {
+	return "binary"
+}
+
+func (BinaryCodec) Unmarshal(data []byte, v any) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return errors.Errorf("%T does not implement encoding.BinaryUnmarshaler", v)
+	}
+
+	return u.UnmarshalBinary(data)
+}
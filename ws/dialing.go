@@ -2,8 +2,10 @@ package ws
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -11,7 +13,6 @@ import (
 	"github.com/yanun0323/errors"
 	"github.com/yanun0323/logs"
 	"github.com/yanun0323/pkg/channel"
-	"github.com/yanun0323/pkg/sys"
 )
 
 const (
@@ -26,7 +27,10 @@ var (
 var (
 	// Debug is the switch of debug log.
 	//
-	// Set debug true to print the debug log of receiving / writing message.
+	// Set debug true to print the debug log of receiving / writing message
+	// for the connections without TraceOption.Tracer.
+	//
+	// Deprecated: use Option.Trace to trace every connection separately.
 	Debug = false
 
 	// ErrNilInstance represents the error of dial
@@ -34,6 +38,9 @@ var (
 
 	// ErrConnectionClose represents the error of trying to send / read data from a closed connection.
 	ErrConnectionClose = errors.New("connection closed")
+
+	// ErrReconnectExhausted represents the error of running out of reconnect attempts or retry budget.
+	ErrReconnectExhausted = errors.New("reconnect exhausted")
 )
 
 type dialing struct {
@@ -43,126 +50,179 @@ type dialing struct {
 	done    chan struct{}
 	close   chan struct{}
 	logger  logs.Logger
+
+	causeMu sync.Mutex
+	cause   error
+
+	// awaiting is the unix nano time of the oldest unreplied heartbeat, zero means none.
+	awaiting  atomic.Int64
+	record    *Recorder
+	metrics   Metrics
+	trace     *tracer
+	closeSent atomic.Bool
 }
 
-// dial creates
-func dial(ctx context.Context, url string, ping ...bool) (*dialing, error) {
-	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+// dialingOption defines the behavior of an established connection.
+type dialingOption struct {
+	ping      bool
+	heartbeat HeartbeatOption
+	recorder  *Recorder
+	metrics   Metrics
+	pooled    bool
+	trace     *tracer
+}
+
+// dial creates a websocket connection to url and starts serving it.
+func dial(ctx context.Context, url string, option Option) (*dialing, error) {
+	dialer := &websocket.Dialer{
+		Proxy:             option.Dialer.Proxy,
+		HandshakeTimeout:  option.Dialer.HandshakeTimeout,
+		TLSClientConfig:   option.Dialer.TLSConfig,
+		ReadBufferSize:    option.Dialer.ReadBufferSize,
+		WriteBufferSize:   option.Dialer.WriteBufferSize,
+		Subprotocols:      option.Dialer.Subprotocols,
+		EnableCompression: option.Dialer.EnableCompression,
+	}
+
+	header := option.Dialer.Header.Clone()
+	if option.Dialer.HeaderProvider != nil {
+		provided, err := option.Dialer.HeaderProvider(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "provide header for (%s)", url)
+		}
+
+		if header == nil {
+			header = make(http.Header, len(provided))
+		}
+		for key, values := range provided {
+			header[key] = values
+		}
+	}
+
+	logger := logs.Get(ctx).With(
+		"dialing", url,
+		"id", newLogID(),
+	)
+	trace := newTracer(option.Trace, logger)
+
+	conn, _, err := dialer.DialContext(ctx, url, header)
+	if err == nil && conn == nil {
+		err = ErrNilInstance
+	}
+	trace.dial(url, err)
 	if err != nil {
 		return nil, errors.Wrapf(err, "dial to (%s)", url)
 	}
 
-	if conn == nil {
-		return nil, errors.Wrapf(ErrNilInstance, "dial to (%s)", url)
+	if option.Dialer.ReadLimit > 0 {
+		conn.SetReadLimit(option.Dialer.ReadLimit)
 	}
 
-	pong := make(chan struct{}, 1)
+	return newDialing(ctx, conn, logger, dialingOption{
+		ping:      option.Ping,
+		heartbeat: option.Heartbeat,
+		recorder:  option.Recorder,
+		metrics:   option.Metrics,
+		pooled:    option.PooledBuffers,
+		trace:     trace,
+	}), nil
+}
+
+// newDialing starts the receiving, pinging and closing goroutines of an established connection.
+//
+// It is shared by the client side dial and the server side Hub.
+func newDialing(ctx context.Context, conn *websocket.Conn, logger logs.Logger, option dialingOption) *dialing {
+	heartbeat := normalizeHeartbeat(option.heartbeat)
 	d := &dialing{
 		conn:    conn,
 		message: make(chan Message, _defaultMessageQueueCap),
 		writeMu: sync.Mutex{},
 		done:    make(chan struct{}, 1),
 		close:   make(chan struct{}),
-		logger: logs.Get(ctx).With(
-			"dialing", url,
-			"id", newLogID(),
-		),
+		logger:  logger,
+		record:  option.recorder,
+		metrics: normalizeMetrics(option.metrics),
+		trace:   option.trace,
 	}
 
+	extendReadDeadline := func() {
+		if heartbeat.ReadDeadline > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(heartbeat.ReadDeadline))
+		}
+	}
+	extendReadDeadline()
+
+	conn.SetPongHandler(func(data string) error {
+		d.acknowledge()
+		extendReadDeadline()
+		d.trace.read(Message{Type: MessageTypePong, Data: []byte(data)})
+		return nil
+	})
+
 	// receiver
 	go func() {
 		defer channel.SafeClose(d.done)
-		defer channel.SafeClose(d.message)
+		// the receiver is the only sender, and SafeClose may consume a buffered message
+		defer close(d.message)
 		for {
-			select {
-			case <-sys.Shutdown():
-				return
-			default:
-				messageType, message, err := conn.ReadMessage()
-				if err != nil {
-					d.logger.Errorf("read message, err: %+v", err)
-					d.logger.Error("stop reading message")
-
+			messageType, message, buf, err := d.read(option.pooled)
+			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok {
+					d.fail(&CloseError{Code: ce.Code, Reason: ce.Text})
+					d.logger.Infof("peer closed, code: %d, reason: %s", ce.Code, ce.Text)
 					return
 				}
 
-				mt := MessageType(messageType)
-				switch mt {
-				case MessageTypeClose:
-					if Debug {
-						d.logger.Infof("%s close message: %s", _symbolRecv, string(message))
-					}
-					return
-				case MessageTypePong:
-					channel.TryPush(pong, struct{}{})
-					if Debug {
-						d.logger.Infof("%s pong message", _symbolRecv)
-					}
-				default:
-					if Debug {
-						msg := fmt.Sprintf("%s %s message: %s", _symbolRecv, mt, string(message))
-						if len(msg) >= _debugMessageLimit {
-							msg = msg[:_debugMessageLimit] + "..."
-						}
-						d.logger.Info(msg)
-					}
-				}
+				d.fail(errors.Wrap(err, "read message"))
+				d.logger.Errorf("read message, err: %+v", err)
+				d.logger.Error("stop reading message")
 
-				d.message <- Message{
-					Type: mt,
-					Data: message,
-				}
+				return
 			}
+
+			extendReadDeadline()
+			d.metrics.MessageIn(len(message))
+
+			msg := Message{
+				Type: MessageType(messageType),
+				Data: message,
+				buf:  buf,
+			}
+
+			d.trace.read(msg)
+
+			if option.ping && heartbeat.Matcher != nil && heartbeat.Matcher(msg) {
+				msg.Release()
+				d.acknowledge()
+				continue
+			}
+
+			// control frames are consumed by the handlers of gorilla/websocket, only data frames reach here
+			d.recordMessage(DirectionInbound, msg)
+			d.message <- msg
 		}
 	}()
 
 	// pinging & checking pong
-	if len(ping) != 0 && ping[0] {
-		go func() {
-			defer channel.SafeClose(d.done)
-			defer channel.SafeClose(pong)
-			ticker := time.NewTicker(5 * time.Second)
-			defer ticker.Stop()
-
-			channel.TryPush(pong, struct{}{})
-
-			for {
-				select {
-				case <-sys.Shutdown():
-					return
-				case <-d.done:
-					return
-				case <-ticker.C:
-					if _, ok := channel.TryReceive(pong); !ok {
-						d.logger.Error("receive no pong message")
-						return
-					}
-
-					d.writeMu.Lock()
-					err := conn.WriteMessage(MessageTypePing.Int(), nil)
-					d.writeMu.Unlock()
-					if err != nil {
-						d.logger.Errorf("ping, err: %+v", err)
-						return
-					}
-
-					d.logger.Debug("ping succeed")
-				}
-			}
-		}()
+	if option.ping {
+		go d.heartbeat(heartbeat)
 	}
 
 	// closing connection
 	go func() {
 		defer channel.SafeClose(d.done)
-		defer channel.SafeClose(pong)
 		select {
-		case <-sys.Shutdown():
 		case <-d.done:
 		case <-ctx.Done():
 		case <-d.close:
 		}
 
+		d.fail(ErrConnectionClose)
+		d.trace.close(d.Err())
+		d.recordMessage(DirectionClose, Message{})
+		if err := d.writeClose(time.Now().Add(_defaultCloseTimeout), CloseNormalClosure, ""); err != nil {
+			d.logger.Debugf("write close frame, err: %+v", err)
+		}
 		if err := conn.Close(); err != nil {
 			d.logger.Errorf("closing dialing, err: %+v", err)
 		} else {
@@ -170,7 +230,56 @@ func dial(ctx context.Context, url string, ping ...bool) (*dialing, error) {
 		}
 	}()
 
-	return d, nil
+	return d
+}
+
+// read reads a frame, into a pooled buffer if pooled is true.
+func (c *dialing) read(pooled bool) (int, []byte, *buffer, error) {
+	if !pooled {
+		messageType, message, err := c.conn.ReadMessage()
+		return messageType, message, nil, err
+	}
+
+	messageType, r, err := c.conn.NextReader()
+	if err != nil {
+		return messageType, nil, nil, err
+	}
+
+	buf, err := readBuffer(r)
+	if err != nil {
+		return messageType, nil, nil, err
+	}
+
+	return messageType, buf.Bytes(), buf, nil
+}
+
+// recordMessage writes the message into the recorder if any.
+func (c *dialing) recordMessage(dir Direction, msg Message) {
+	if c.record == nil {
+		return
+	}
+
+	if err := c.record.Record(dir, msg); err != nil {
+		c.logger.Errorf("record %s message, err: %+v", dir, err)
+	}
+}
+
+// fail records the first cause of the connection closing.
+func (c *dialing) fail(err error) {
+	c.causeMu.Lock()
+	defer c.causeMu.Unlock()
+
+	if c.cause == nil {
+		c.cause = err
+	}
+}
+
+// Err returns the cause of the connection closing.
+func (c *dialing) Err() error {
+	c.causeMu.Lock()
+	defer c.causeMu.Unlock()
+
+	return c.cause
 }
 
 func (c *dialing) IsClose() bool {
@@ -196,21 +305,21 @@ func (c *dialing) WriteJSON(v any) error {
 		return errors.Wrap(ErrConnectionClose, "dialing closed")
 	}
 
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "marshal json (%v)", v)
+	}
+
 	c.writeMu.Lock()
-	err := c.conn.WriteJSON(v)
+	err = c.conn.WriteMessage(MessageTypeText.Int(), data)
 	c.writeMu.Unlock()
+	c.trace.write(Message{Type: MessageTypeText, Data: data}, err)
 	if err != nil {
 		return errors.Wrapf(err, "write json (%v)", v)
 	}
 
-	if Debug {
-		msg := fmt.Sprintf("%s write json (%v)", _symbolWrite, v)
-		if len(msg) >= _debugMessageLimit {
-			msg = msg[:_debugMessageLimit] + "..."
-		}
-
-		c.logger.Info(msg)
-	}
+	c.metrics.MessageOut(len(data))
+	c.recordMessage(DirectionOutbound, Message{Type: MessageTypeText, Data: data})
 
 	return nil
 }
@@ -223,6 +332,7 @@ func (c *dialing) WriteRaw(messageType MessageType, data []byte) error {
 	c.writeMu.Lock()
 	err := c.conn.WriteMessage(messageType.Int(), data)
 	c.writeMu.Unlock()
+	c.trace.write(Message{Type: messageType, Data: data}, err)
 	if err != nil {
 		return errors.Wrapf(err, "write message. type(%s) data(%s)",
 			messageType,
@@ -230,14 +340,8 @@ func (c *dialing) WriteRaw(messageType MessageType, data []byte) error {
 		)
 	}
 
-	if Debug {
-		msg := fmt.Sprintf("%s write raw [%s](%s)", _symbolWrite, messageType, string(data))
-		if len(msg) >= _debugMessageLimit {
-			msg = msg[:_debugMessageLimit] + "..."
-		}
-
-		c.logger.Info(msg)
-	}
+	c.metrics.MessageOut(len(data))
+	c.recordMessage(DirectionOutbound, Message{Type: messageType, Data: data})
 
 	return nil
 }
@@ -0,0 +1,232 @@
+package ws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/yanun0323/pkg/channel"
+)
+
+const (
+	_defaultEventQueueCap int = 100
+)
+
+// State represents the connection state of WebSocket.
+type State int32
+
+const (
+	// StateIdle means the websocket is not started yet.
+	StateIdle State = iota
+	// StateConnecting means the websocket is dialing to the url.
+	StateConnecting
+	// StateConnected means the connection is established and the registers are running.
+	StateConnected
+	// StateReady means all registers succeed and the connection is available.
+	StateReady
+	// StateDisconnected means the connection is lost or failed to be established.
+	StateDisconnected
+	// StateBackoff means the websocket is waiting for the next reconnect attempt.
+	StateBackoff
+	// StateClosed means the websocket is closed and will never reconnect.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateIdle:
+		return "Idle"
+	case StateConnecting:
+		return "Connecting"
+	case StateConnected:
+		return "Connected"
+	case StateReady:
+		return "Ready"
+	case StateDisconnected:
+		return "Disconnected"
+	case StateBackoff:
+		return "Backoff"
+	case StateClosed:
+		return "Closed"
+	default:
+		return fmt.Sprintf("Unknown(%d)", s)
+	}
+}
+
+// EventType represents the type of connection lifecycle Event.
+type EventType int
+
+const (
+	// EventConnecting is emitted before every dial attempt.
+	EventConnecting EventType = iota + 1
+	// EventConnected is emitted after the connection is established, before running registers.
+	EventConnected
+	// EventDialFailed is emitted when a dial attempt fails, Event.Err holds the cause.
+	EventDialFailed
+	// EventRegistered is emitted after all registers succeed.
+	EventRegistered
+	// EventRegisterFailed is emitted when a register fails, Event.Err holds the cause.
+	EventRegisterFailed
+	// EventDisconnected is emitted when an established connection is lost, Event.Err holds the cause.
+	EventDisconnected
+	// EventBackoffScheduled is emitted before waiting for the next attempt, Event.Delay holds the delay.
+	EventBackoffScheduled
+	// EventClosed is emitted when the websocket is closed.
+	EventClosed
+	// EventGaveUp is emitted when the backoff stops reconnecting, Event.Err holds the terminal error.
+	//
+	// EventClosed always follows.
+	EventGaveUp
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnecting:
+		return "Connecting"
+	case EventConnected:
+		return "Connected"
+	case EventDialFailed:
+		return "DialFailed"
+	case EventRegistered:
+		return "Registered"
+	case EventRegisterFailed:
+		return "RegisterFailed"
+	case EventDisconnected:
+		return "Disconnected"
+	case EventBackoffScheduled:
+		return "BackoffScheduled"
+	case EventClosed:
+		return "Closed"
+	case EventGaveUp:
+		return "GaveUp"
+	default:
+		return fmt.Sprintf("Unknown(%d)", t)
+	}
+}
+
+// State returns the connection state after the event.
+func (t EventType) State() State {
+	switch t {
+	case EventConnecting:
+		return StateConnecting
+	case EventConnected:
+		return StateConnected
+	case EventRegistered:
+		return StateReady
+	case EventDialFailed, EventRegisterFailed, EventDisconnected, EventGaveUp:
+		return StateDisconnected
+	case EventBackoffScheduled:
+		return StateBackoff
+	case EventClosed:
+		return StateClosed
+	default:
+		return StateIdle
+	}
+}
+
+// Event is a connection lifecycle event of WebSocket.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Err is the cause of EventDialFailed, EventRegisterFailed, EventDisconnected and EventGaveUp.
+	Err error
+	// Delay is the backoff delay of EventBackoffScheduled.
+	Delay time.Duration
+	// Attempt is the number of reconnect attempts since the last available connection.
+	Attempt int
+}
+
+func (e Event) String() string {
+	switch {
+	case e.Err != nil:
+		return fmt.Sprintf("%s (attempt %d): %v", e.Type, e.Attempt, e.Err)
+	case e.Type == EventBackoffScheduled:
+		return fmt.Sprintf("%s (attempt %d): %s", e.Type, e.Attempt, e.Delay)
+	default:
+		return fmt.Sprintf("%s (attempt %d)", e.Type, e.Attempt)
+	}
+}
+
+// State returns the current connection state
+func (ws *WebSocket) State() State {
+	return State(ws.state.Load())
+}
+
+// Events subscribes the connection lifecycle events
+//
+// Events are dropped when the channel is full.
+func (ws *WebSocket) Events() (<-chan Event, func()) {
+	ws.eventsLock.Lock()
+	defer ws.eventsLock.Unlock()
+
+	id := ws.nextID.Add(1)
+	ch := make(chan Event, _defaultEventQueueCap)
+	if ws.State() == StateClosed {
+		close(ch)
+		return ch, func() {}
+	}
+	ws.events[id] = ch
+
+	unsubscribe := func() {
+		ws.eventsLock.Lock()
+		defer ws.eventsLock.Unlock()
+
+		if ch, ok := ws.events[id]; ok {
+			close(ch)
+			delete(ws.events, id)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+func (ws *WebSocket) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	// OnEvent is invoked after releasing the lock, so it may call Close, Events or unsubscribe.
+	if onEvent := ws.dispatch(e); onEvent != nil {
+		onEvent(e)
+	}
+}
+
+// dispatch updates the state with e and pushes it to the subscribers, returns the OnEvent callback to be invoked.
+//
+// The pushes never block, so they are done under the lock to keep them from racing unsubscribe.
+func (ws *WebSocket) dispatch(e Event) func(Event) {
+	ws.eventsLock.Lock()
+	defer ws.eventsLock.Unlock()
+
+	if ws.State() == StateClosed {
+		return nil
+	}
+
+	ws.state.Store(int32(e.Type.State()))
+	switch e.Type {
+	case EventConnected:
+		if !ws.online.Swap(true) {
+			ws.option.Metrics.Connected(ws.ready.Load())
+		}
+	case EventRegistered:
+		ws.ready.Store(true)
+	case EventRegisterFailed, EventDisconnected, EventGaveUp, EventClosed:
+		if ws.online.Swap(false) {
+			ws.option.Metrics.Disconnected()
+		}
+	}
+
+	for _, ch := range ws.events {
+		if !channel.TryPush(ch, e) {
+			ws.logger.Warnf("event (%s) dropped for a subscriber, channel is full", e.Type)
+		}
+	}
+
+	if e.Type == EventClosed {
+		for id, ch := range ws.events {
+			close(ch)
+			delete(ws.events, id)
+		}
+	}
+
+	return ws.option.OnEvent
+}
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+
+	client := New(ctx, url)
+	tester.RequireEqual(t, StateIdle, client.State())
+
+	events, unsubscribe := client.Events()
+	defer unsubscribe()
+
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	for _, want := range []EventType{EventBackoffScheduled, EventConnecting, EventConnected, EventRegistered} {
+		tester.RequireEqual(t, want, waitMessage(t, events).Type)
+	}
+	tester.RequireEqual(t, StateReady, client.State())
+	waitPeers(t, hub, 1)
+
+	for _, p := range hub.Peers() {
+		p.Close()
+	}
+
+	e := waitMessage(t, events)
+	tester.RequireEqual(t, EventDisconnected, e.Type)
+	tester.RequireNotNil(t, e.Err)
+
+	for _, want := range []EventType{EventBackoffScheduled, EventConnecting, EventConnected, EventRegistered} {
+		tester.RequireEqual(t, want, waitMessage(t, events).Type)
+	}
+
+	client.Close()
+	tester.RequireEqual(t, EventClosed, waitMessage(t, events).Type)
+	tester.RequireEqual(t, StateClosed, client.State())
+
+	_, ok := <-events
+	tester.RequireFalse(t, ok)
+}
+
+func TestOnEventClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, url := newTestHub(t, ctx)
+
+	var client *WebSocket
+	closed := make(chan struct{})
+	client = New(ctx, url, Option{
+		OnEvent: func(e Event) {
+			switch e.Type {
+			case EventRegistered:
+				_, unsubscribe := client.Events()
+				unsubscribe()
+				client.Close()
+			case EventClosed:
+				close(closed)
+			}
+		},
+	})
+	tester.RequireNoError(t, client.Start(ctx))
+
+	select {
+	case <-closed:
+	case <-time.After(3 * time.Second):
+		t.Fatal("close inside OnEvent deadlocked")
+	}
+	tester.RequireEqual(t, StateClosed, client.State())
+}
+
+func TestContextClose(t *testing.T) {
+	_, url := newTestHub(t, t.Context())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := New(context.Background(), url)
+	defer client.Close()
+
+	events, unsubscribeEvents := client.Events()
+	defer unsubscribeEvents()
+	messages, unsubscribe := client.Subscribe()
+	defer unsubscribe()
+	typed, errs, unsubscribeTyped := SubscribeTyped[map[string]any](client, nil)
+	defer unsubscribeTyped()
+
+	tester.RequireNoError(t, client.Start(ctx))
+	cancel()
+
+	for waitMessage(t, events).Type != EventClosed {
+	}
+	tester.RequireEqual(t, StateClosed, client.State())
+
+	_, ok := <-events
+	tester.RequireFalse(t, ok)
+	_, ok = <-messages
+	tester.RequireFalse(t, ok)
+	tester.RequireTrue(t, client.IsClose())
+	_, ok = <-typed
+	tester.RequireFalse(t, ok)
+	_, ok = <-errs
+	tester.RequireFalse(t, ok)
+
+	newCtx, newCancel := context.WithCancel(context.Background())
+	idle := New(newCtx, url)
+	newCancel()
+	select {
+	case <-idle.shutdown:
+	case <-time.After(3 * time.Second):
+		t.Fatal("websocket is not closed with the context of New")
+	}
+	tester.RequireEqual(t, StateClosed, idle.State())
+}
+
+func TestBindSignal(t *testing.T) {
+	ws := New(t.Context(), "")
+	signal := make(chan os.Signal)
+	stopped := make(chan struct{}, 1)
+	go func() {
+		bindSignal(signal, ws.shutdown, ws.Close)
+		stopped <- struct{}{}
+	}()
+
+	close(signal)
+	waitMessage(t, stopped)
+	tester.RequireTrue(t, ws.IsClose())
+
+	other := New(t.Context(), "")
+	go func() {
+		bindSignal(make(chan os.Signal), other.shutdown, other.Close)
+		stopped <- struct{}{}
+	}()
+
+	other.Close()
+	waitMessage(t, stopped)
+}
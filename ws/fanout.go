@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yanun0323/logs"
+)
+
+// subscriptionIDs generates the ids of subscriptions unique across fanouts,
+// so the labels of a Metrics shared by the sockets of a Pool never collide.
+var subscriptionIDs atomic.Uint64
+
+// fanout delivers messages to subscribers with their overflow policies.
+//
+// It is shared by WebSocket and Pool.
+type fanout struct {
+	subscribers     map[uint64]*subscriber[Message]
+	topics          map[string]map[uint64]*subscriber[Message]
+	typed           map[typedKey]typedSubscribers
+	subscribersLock sync.RWMutex
+	dropped         atomic.Uint64
+	decodeErrors    atomic.Uint64
+
+	router  Router
+	metrics Metrics
+	logger  logs.Logger
+}
+
+func newFanout(router Router, metrics Metrics, logger logs.Logger) *fanout {
+	return &fanout{
+		subscribers: make(map[uint64]*subscriber[Message]),
+		topics:      make(map[string]map[uint64]*subscriber[Message]),
+		typed:       make(map[typedKey]typedSubscribers),
+		router:      router,
+		metrics:     normalizeMetrics(metrics),
+		logger:      logger,
+	}
+}
+
+func (f *fanout) subscribe(option SubscribeOption) (<-chan Message, func()) {
+	f.subscribersLock.Lock()
+	defer f.subscribersLock.Unlock()
+
+	id := subscriptionIDs.Add(1)
+	sub := newSubscriber(id, normalizeSubscribeOption(option, f.router), messageOf)
+	sub.onDrop, sub.onClose = f.track("subscribe#"+strconv.FormatUint(id, 10), sub.option.Overflow)
+	f.subscribers[id] = sub
+
+	return sub.ch, func() { f.unsubscribe(id) }
+}
+
+func (f *fanout) subscribeTopic(key string, option SubscribeOption) (<-chan Message, func()) {
+	f.subscribersLock.Lock()
+	defer f.subscribersLock.Unlock()
+
+	if f.router == nil {
+		f.logger.Warnf("subscribe topic (%s) without router, no message will be delivered", key)
+	}
+
+	id := subscriptionIDs.Add(1)
+	sub := newSubscriber(id, normalizeSubscribeOption(option, f.router), messageOf)
+	sub.onDrop, sub.onClose = f.track("topic:"+key+"#"+strconv.FormatUint(id, 10), sub.option.Overflow)
+
+	subscribers, ok := f.topics[key]
+	if !ok {
+		subscribers = make(map[uint64]*subscriber[Message])
+		f.topics[key] = subscribers
+	}
+	subscribers[id] = sub
+
+	return sub.ch, func() { f.unsubscribe(id) }
+}
+
+func (f *fanout) unsubscribe(id uint64) {
+	f.subscribersLock.Lock()
+	defer f.subscribersLock.Unlock()
+
+	if sub, ok := f.subscribers[id]; ok {
+		sub.close()
+		delete(f.subscribers, id)
+		return
+	}
+
+	for key, subscribers := range f.topics {
+		sub, ok := subscribers[id]
+		if !ok {
+			continue
+		}
+
+		sub.close()
+		delete(subscribers, id)
+		if len(subscribers) == 0 {
+			delete(f.topics, key)
+		}
+		return
+	}
+}
+
+// broadcast delivers msg to the subscribers.
+//
+// The subscribers are snapshotted under the lock and delivered outside it,
+// so OverflowBlock never holds the lock while waiting for a slow subscriber,
+// and the codecs and filters of typed subscriptions may subscribe or unsubscribe.
+func (f *fanout) broadcast(msg Message) {
+	f.subscribersLock.RLock()
+	subscribers := make([]*subscriber[Message], 0, len(f.subscribers))
+	for _, sub := range f.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+
+	if f.router != nil && len(f.topics) != 0 {
+		if key, ok := f.router(msg); ok {
+			for _, sub := range f.topics[key] {
+				subscribers = append(subscribers, sub)
+			}
+		}
+	}
+
+	typed := make([]func(*fanout, Message), 0, len(f.typed))
+	for _, group := range f.typed {
+		typed = append(typed, group.snapshot())
+	}
+	f.subscribersLock.RUnlock()
+
+	for _, deliver := range typed {
+		deliver(f, msg)
+	}
+
+	var disconnected []uint64
+	for _, sub := range subscribers {
+		if !sub.push(msg.Retain()) {
+			disconnected = append(disconnected, sub.id)
+		}
+	}
+
+	for _, id := range disconnected {
+		f.unsubscribe(id)
+	}
+}
+
+// track returns the drop and close hooks of the subscription labeled by subscription,
+// see Metrics.Dropped and Metrics.Unsubscribed.
+func (f *fanout) track(subscription string, overflow Overflow) (onDrop func(), onClose func()) {
+	onDrop = func() {
+		f.dropped.Add(1)
+		f.metrics.Dropped(subscription, 1)
+		f.logger.Warnf("broadcast message dropped for subscription (%s), overflow policy: %s", subscription, overflow)
+	}
+	onClose = func() {
+		f.metrics.Unsubscribed(subscription)
+	}
+
+	return onDrop, onClose
+}
+
+func (f *fanout) close() {
+	f.subscribersLock.Lock()
+	defer f.subscribersLock.Unlock()
+
+	for id, sub := range f.subscribers {
+		sub.close()
+		delete(f.subscribers, id)
+	}
+
+	for key, subscribers := range f.topics {
+		for _, sub := range subscribers {
+			sub.close()
+		}
+		delete(f.topics, key)
+	}
+
+	for key, group := range f.typed {
+		group.close()
+		delete(f.typed, key)
+	}
+}
+
+func (f *fanout) len() int {
+	f.subscribersLock.RLock()
+	defer f.subscribersLock.RUnlock()
+
+	count := len(f.subscribers)
+	for _, subscribers := range f.topics {
+		count += len(subscribers)
+	}
+	for _, group := range f.typed {
+		count += group.len()
+	}
+
+	return count
+}
+
+// pending returns the number of messages queued in all subscriptions.
+func (f *fanout) pending() int {
+	f.subscribersLock.RLock()
+	defer f.subscribersLock.RUnlock()
+
+	count := 0
+	for _, sub := range f.subscribers {
+		count += sub.queued()
+	}
+	for _, subscribers := range f.topics {
+		for _, sub := range subscribers {
+			count += sub.queued()
+		}
+	}
+	for _, group := range f.typed {
+		count += group.pending()
+	}
+
+	return count
+}
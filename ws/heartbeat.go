@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/yanun0323/errors"
+)
+
+const (
+	_defaultHeartbeatInterval = 5 * time.Second
+)
+
+// HeartbeatOption defines the keep alive behavior of the connection.
+//
+// ReadDeadline always takes effect, the other fields take effect when Ping is enabled.
+type HeartbeatOption struct {
+	// Interval is the duration between two heartbeats.
+	//
+	// Zero means using the default interval 5s.
+	Interval time.Duration
+	// PongTimeout is the maximum duration to wait for the reply of a heartbeat.
+	//
+	// Zero means using Interval.
+	PongTimeout time.Duration
+	// ReadDeadline closes the connection when no frame is received within the duration.
+	//
+	// It is applied with or without Ping. Zero means no read deadline.
+	ReadDeadline time.Duration
+	// Payload returns the application level heartbeat message, e.g. {"op":"ping"}.
+	//
+	// Nil means sending a ping control frame.
+	Payload func() Message
+	// Matcher reports whether an incoming message is the reply of the application level heartbeat.
+	//
+	// Matched messages are consumed by the heartbeat and never delivered to subscribers.
+	// Nil means only pong control frames are treated as the reply.
+	Matcher func(Message) bool
+}
+
+func normalizeHeartbeat(option HeartbeatOption) HeartbeatOption {
+	if option.Interval <= 0 {
+		option.Interval = _defaultHeartbeatInterval
+	}
+	if option.PongTimeout <= 0 {
+		option.PongTimeout = option.Interval
+	}
+
+	return option
+}
+
+// JSONHeartbeat returns a HeartbeatOption sending ping as a JSON text message,
+// and treating the messages whose JSON field key equals pong as the reply.
+//
+// e.g. JSONHeartbeat(map[string]string{"op": "ping"}, "op", "pong")
+func JSONHeartbeat(ping any, key, pong string) HeartbeatOption {
+	router := JSONRouter(key)
+	return HeartbeatOption{
+		Payload: func() Message {
+			data, err := json.Marshal(ping)
+			if err != nil {
+				return Message{Type: MessageTypeText}
+			}
+			return Message{Type: MessageTypeText, Data: data}
+		},
+		Matcher: func(msg Message) bool {
+			value, ok := router(msg)
+			return ok && value == pong
+		},
+	}
+}
+
+// acknowledge marks the outstanding heartbeat as replied and reports the round trip time.
+func (c *dialing) acknowledge() {
+	if sent := c.awaiting.Swap(0); sent != 0 {
+		c.metrics.PingRTT(time.Since(time.Unix(0, sent)))
+	}
+}
+
+// heartbeat sends heartbeats and closes the connection when the reply times out.
+func (c *dialing) heartbeat(option HeartbeatOption) {
+	defer c.Close()
+
+	period := min(option.Interval, option.PongTimeout)
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	var lastSent time.Time
+	for {
+		select {
+		case <-c.done:
+			return
+		case now := <-ticker.C:
+			if awaiting := c.awaiting.Load(); awaiting != 0 && now.Sub(time.Unix(0, awaiting)) >= option.PongTimeout {
+				c.fail(errors.New("receive no pong message"))
+				c.logger.Error("receive no pong message")
+				return
+			}
+
+			if now.Sub(lastSent) < option.Interval {
+				continue
+			}
+
+			if err := c.ping(option); err != nil {
+				c.fail(errors.Wrap(err, "ping"))
+				c.logger.Errorf("ping, err: %+v", err)
+				return
+			}
+
+			lastSent = now
+			c.awaiting.CompareAndSwap(0, time.Now().UnixNano())
+			c.logger.Debug("ping succeed")
+		}
+	}
+}
+
+// ping writes a heartbeat, the application level one goes through WriteRaw to be traced and recorded like other messages.
+func (c *dialing) ping(option HeartbeatOption) error {
+	if option.Payload != nil {
+		msg := option.Payload()
+		return c.WriteRaw(msg.Type, msg.Data)
+	}
+
+	c.writeMu.Lock()
+	err := c.conn.WriteMessage(MessageTypePing.Int(), nil)
+	c.writeMu.Unlock()
+	c.trace.write(Message{Type: MessageTypePing}, err)
+	if err != nil {
+		return err
+	}
+
+	c.metrics.MessageOut(0)
+	return nil
+}
@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestJSONHeartbeat(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	var (
+		silent atomic.Bool
+		pings  atomic.Int64
+	)
+	go func() {
+		for msg := range inbound {
+			pings.Add(1)
+			if !silent.Load() {
+				_ = msg.Peer.WriteJSON(map[string]string{"op": "pong"})
+			}
+		}
+	}()
+
+	heartbeat := JSONHeartbeat(map[string]string{"op": "ping"}, "op", "pong")
+	heartbeat.Interval = 20 * time.Millisecond
+	// a generous timeout keeps a slow pong under -race from reconnecting
+	heartbeat.PongTimeout = 500 * time.Millisecond
+	client := New(ctx, url, Option{Ping: true, Heartbeat: heartbeat})
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	messages, unsubscribeMessages := client.Subscribe()
+	defer unsubscribeMessages()
+	events, unsubscribeEvents := client.Events()
+	defer unsubscribeEvents()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for pings.Load() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.RequireTrue(t, pings.Load() >= 5)
+	tester.RequireEqual(t, 0, len(messages))
+	tester.RequireEqual(t, StateReady, client.State())
+	for len(events) != 0 {
+		tester.RequireNotEqual(t, EventDisconnected, (<-events).Type)
+	}
+
+	silent.Store(true)
+
+	e := waitMessage(t, events)
+	tester.RequireEqual(t, EventDisconnected, e.Type)
+	tester.RequireNotNil(t, e.Err)
+}
@@ -44,6 +44,9 @@ func (mt MessageType) String() string {
 type Message struct {
 	Type MessageType
 	Data []byte
+
+	// buf is the pooled buffer backing Data, nil if Data is not pooled.
+	buf *buffer
 }
 
 func (m Message) String() string {
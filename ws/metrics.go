@@ -0,0 +1,224 @@
+package ws
+
+import (
+	"expvar"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the measurements of connections.
+//
+// Implement it to adapt the measurements to Prometheus or other collectors,
+// or use ExpvarMetrics to expose them without extra dependencies.
+// The methods are invoked concurrently and must not block.
+type Metrics interface {
+	// MessageIn is invoked with every message received from the peer.
+	MessageIn(bytes int)
+	// MessageOut is invoked with every message written to the peer.
+	MessageOut(bytes int)
+	// Dropped is invoked when messages are dropped by the overflow policy of a subscription.
+	//
+	// subscription identifies the subscriber, "subscribe#<id>" for Subscribe, "topic:<key>#<id>" for SubscribeTopic
+	// and "typed:<type>#<id>" for SubscribeTyped.
+	Dropped(subscription string, n uint64)
+	// Unsubscribed is invoked when a subscription is closed, so its Dropped counter can be forgotten.
+	Unsubscribed(subscription string)
+	// Connected is invoked when a connection is established.
+	//
+	// reconnected is true when the websocket was ready before, i.e. the connection replaces a lost one.
+	Connected(reconnected bool)
+	// Disconnected is invoked when an established connection is lost or closed.
+	Disconnected()
+	// PingRTT is invoked with the round trip time of every replied heartbeat.
+	PingRTT(rtt time.Duration)
+	// SendAndWait is invoked with the latency and result of every SendAndWait.
+	SendAndWait(latency time.Duration, err error)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) MessageIn(int)                    {}
+func (nopMetrics) MessageOut(int)                   {}
+func (nopMetrics) Dropped(string, uint64)           {}
+func (nopMetrics) Unsubscribed(string)              {}
+func (nopMetrics) Connected(bool)                   {}
+func (nopMetrics) Disconnected()                    {}
+func (nopMetrics) PingRTT(time.Duration)            {}
+func (nopMetrics) SendAndWait(time.Duration, error) {}
+
+func normalizeMetrics(m Metrics) Metrics {
+	if m == nil {
+		return nopMetrics{}
+	}
+
+	return m
+}
+
+var (
+	// DefaultLatencyBuckets is the upper bounds of the SendAndWait latency histogram of ExpvarMetrics.
+	DefaultLatencyBuckets = []time.Duration{
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		25 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		250 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		2500 * time.Millisecond,
+		5 * time.Second,
+		10 * time.Second,
+	}
+)
+
+// ExpvarMetrics is a Metrics publishing the measurements as an expvar.Map.
+//
+// Share it across connections to aggregate their measurements.
+type ExpvarMetrics struct {
+	vars *expvar.Map
+
+	messagesIn        expvar.Int
+	messagesOut       expvar.Int
+	bytesIn           expvar.Int
+	bytesOut          expvar.Int
+	dropped           expvar.Map
+	reconnects        expvar.Int
+	pingRTT           expvar.Float
+	sendAndWaitErrors expvar.Int
+
+	connects       atomic.Int64
+	connections    atomic.Int64
+	connectedSince atomic.Int64
+	connectedTotal atomic.Int64
+	latency        *histogram
+}
+
+// NewExpvarMetrics creates an ExpvarMetrics published as name.
+//
+// Like expvar.Publish, it panics if name is already published.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{
+		vars:    expvar.NewMap(name),
+		latency: newHistogram(DefaultLatencyBuckets),
+	}
+
+	m.dropped.Init()
+	m.vars.Set("messages_in", &m.messagesIn)
+	m.vars.Set("messages_out", &m.messagesOut)
+	m.vars.Set("bytes_in", &m.bytesIn)
+	m.vars.Set("bytes_out", &m.bytesOut)
+	m.vars.Set("dropped", &m.dropped)
+	m.vars.Set("connects", expvar.Func(func() any { return m.connects.Load() }))
+	m.vars.Set("reconnects", &m.reconnects)
+	m.vars.Set("connections", expvar.Func(func() any { return m.connections.Load() }))
+	m.vars.Set("connected_seconds", expvar.Func(func() any { return m.ConnectedTime().Seconds() }))
+	m.vars.Set("ping_rtt_seconds", &m.pingRTT)
+	m.vars.Set("send_and_wait_errors", &m.sendAndWaitErrors)
+	m.vars.Set("send_and_wait_seconds", expvar.Func(func() any { return m.latency.snapshot() }))
+
+	return m
+}
+
+// Vars returns the published expvar.Map.
+func (m *ExpvarMetrics) Vars() *expvar.Map {
+	return m.vars
+}
+
+// ConnectedTime returns the total duration of established connections, including the current one.
+func (m *ExpvarMetrics) ConnectedTime() time.Duration {
+	total := time.Duration(m.connectedTotal.Load())
+	if since := m.connectedSince.Load(); since != 0 {
+		total += time.Since(time.Unix(0, since))
+	}
+
+	return total
+}
+
+func (m *ExpvarMetrics) MessageIn(bytes int) {
+	m.messagesIn.Add(1)
+	m.bytesIn.Add(int64(bytes))
+}
+
+func (m *ExpvarMetrics) MessageOut(bytes int) {
+	m.messagesOut.Add(1)
+	m.bytesOut.Add(int64(bytes))
+}
+
+func (m *ExpvarMetrics) Dropped(subscription string, n uint64) {
+	m.dropped.Add(subscription, int64(n))
+}
+
+// Unsubscribed deletes the dropped counter of the subscription, so the map does not grow with short-lived subscriptions.
+func (m *ExpvarMetrics) Unsubscribed(subscription string) {
+	m.dropped.Delete(subscription)
+}
+
+func (m *ExpvarMetrics) Connected(reconnected bool) {
+	m.connects.Add(1)
+	if reconnected {
+		m.reconnects.Add(1)
+	}
+
+	if m.connections.Add(1) == 1 {
+		m.connectedSince.Store(time.Now().UnixNano())
+	}
+}
+
+func (m *ExpvarMetrics) Disconnected() {
+	if m.connections.Add(-1) != 0 {
+		return
+	}
+
+	if since := m.connectedSince.Swap(0); since != 0 {
+		m.connectedTotal.Add(int64(time.Since(time.Unix(0, since))))
+	}
+}
+
+func (m *ExpvarMetrics) PingRTT(rtt time.Duration) {
+	m.pingRTT.Set(rtt.Seconds())
+}
+
+func (m *ExpvarMetrics) SendAndWait(latency time.Duration, err error) {
+	if err != nil {
+		m.sendAndWaitErrors.Add(1)
+	}
+	m.latency.observe(latency)
+}
+
+// histogram counts the observed durations into cumulative buckets.
+type histogram struct {
+	bounds []time.Duration
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func newHistogram(bounds []time.Duration) *histogram {
+	return &histogram{
+		bounds: bounds,
+		counts: make([]atomic.Uint64, len(bounds)),
+	}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	for i, bound := range h.bounds {
+		if d <= bound {
+			h.counts[i].Add(1)
+		}
+	}
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *histogram) snapshot() map[string]any {
+	buckets := make(map[string]uint64, len(h.bounds))
+	for i, bound := range h.bounds {
+		buckets[bound.String()] = h.counts[i].Load()
+	}
+
+	return map[string]any{
+		"buckets": buckets,
+		"count":   h.count.Load(),
+		"sum":     time.Duration(h.sum.Load()).Seconds(),
+	}
+}
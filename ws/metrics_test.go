@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestExpvarMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	go func() {
+		for msg := range inbound {
+			_ = msg.Peer.WriteRaw(msg.Message.Type, msg.Message.Data)
+		}
+	}()
+
+	name := "ws_test_metrics_" + newLogID()
+	metrics := NewExpvarMetrics(name)
+	tester.RequireTrue(t, expvar.Get(name) == metrics.Vars())
+
+	client := New(ctx, url, Option{
+		Ping:      true,
+		Heartbeat: HeartbeatOption{Interval: 20 * time.Millisecond},
+		Metrics:   metrics,
+	})
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	tester.RequireNoError(t, client.SendAndWait(ctx, Sidecar{
+		Sender: func(_ context.Context, ws *WebSocket) error {
+			return ws.WriteRaw(MessageTypeText, []byte("hello"))
+		},
+		Waiter: func(_ context.Context, msg Message) (bool, error) {
+			return string(msg.Data) == "hello", nil
+		},
+	}))
+
+	tester.RequireEqual(t, int64(1), metrics.messagesIn.Value())
+	tester.RequireEqual(t, int64(5), metrics.bytesIn.Value())
+	tester.RequireEqual(t, uint64(1), metrics.latency.count.Load())
+	tester.RequireEqual(t, int64(1), metrics.connections.Load())
+
+	deadline := time.Now().Add(3 * time.Second)
+	for metrics.pingRTT.Value() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.RequireTrue(t, metrics.pingRTT.Value() > 0)
+
+	client.Reconnect()
+	for metrics.reconnects.Value() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.RequireEqual(t, int64(1), metrics.reconnects.Value())
+
+	client.Close()
+	tester.RequireEqual(t, int64(0), metrics.connections.Load())
+	tester.RequireTrue(t, metrics.ConnectedTime() > 0)
+	tester.RequireTrue(t, metrics.Vars().Get("send_and_wait_seconds") != nil)
+}
+
+func TestMetricsDroppedPerSubscriber(t *testing.T) {
+	metrics := NewExpvarMetrics("ws_test_dropped_" + newLogID())
+	ws := New(t.Context(), "", Option{Router: JSONRouter("k"), Metrics: metrics})
+
+	_, unsubscribe := ws.Subscribe(SubscribeOption{Cap: 1})
+	defer unsubscribe()
+	_, unsubscribeTopic := ws.SubscribeTopic("a", SubscribeOption{Cap: 1})
+
+	var subscription, topic string
+	for id := range ws.fanout.subscribers {
+		subscription = fmt.Sprintf("subscribe#%d", id)
+	}
+	for id := range ws.fanout.topics["a"] {
+		topic = fmt.Sprintf("topic:a#%d", id)
+	}
+
+	ws.broadcast(textMessage(`{"k":"a"}`))
+	ws.broadcast(textMessage(`{"k":"a"}`))
+	ws.broadcast(textMessage(`{"k":"b"}`))
+
+	tester.RequireEqual(t, "2", metrics.dropped.Get(subscription).String())
+	tester.RequireEqual(t, "1", metrics.dropped.Get(topic).String())
+	tester.RequireEqual(t, uint64(3), ws.Dropped())
+
+	unsubscribeTopic()
+	tester.RequireNil(t, metrics.dropped.Get(topic))
+	tester.RequireNotNil(t, metrics.dropped.Get(subscription))
+}
+
+func TestMetricsSharedByPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	metrics := NewExpvarMetrics("ws_test_pool_" + newLogID())
+
+	pool := NewPool(ctx, url, PoolOption{Size: 2, Option: Option{Metrics: metrics}})
+	defer pool.Close()
+	tester.RequireNoError(t, pool.Start(ctx))
+	waitPeers(t, hub, 2)
+
+	tester.RequireEqual(t, int64(2), metrics.connects.Load())
+	tester.RequireEqual(t, int64(0), metrics.reconnects.Value())
+}
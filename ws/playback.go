@@ -0,0 +1,207 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlaybackOption defines how Playback replays the records.
+type PlaybackOption struct {
+	// Speed is the playback rate of the recorded timing, e.g. 1 for real-time and 10 for 10x accelerated.
+	//
+	// Zero means replaying instantly without delay.
+	Speed float64
+	// IgnoreOutbound replays the inbound records without waiting for the client messages.
+	//
+	// By default, every outbound record waits for a message from the client,
+	// so the registers are replayed in the recorded order.
+	IgnoreOutbound bool
+}
+
+// Playback serves recorded messages through a local websocket server for deterministic tests.
+//
+// Every accepted connection continues from the record where the previous connection stopped,
+// and a DirectionClose record closes the connection to replay the reconnection.
+type Playback struct {
+	records []Record
+	option  PlaybackOption
+	hub     *Hub
+	server  *httptest.Server
+	cancel  context.CancelFunc
+
+	mu     sync.Mutex
+	cursor int
+}
+
+// NewPlayback starts a local websocket server replaying records.
+func NewPlayback(ctx context.Context, records []Record, opts ...PlaybackOption) *Playback {
+	option := PlaybackOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Playback{
+		records: records,
+		option:  option,
+		hub:     NewHub(ctx),
+		cancel:  cancel,
+	}
+
+	inbound, _ := p.hub.Subscribe()
+	p.server = httptest.NewServer(p.hub)
+	go p.serve(ctx, inbound)
+
+	return p
+}
+
+// URL returns the websocket url of the playback server.
+func (p *Playback) URL() string {
+	return "ws" + strings.TrimPrefix(p.server.URL, "http")
+}
+
+// Done reports whether all records are replayed.
+func (p *Playback) Done() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.cursor >= len(p.records)
+}
+
+// Close stops the playback server.
+func (p *Playback) Close() {
+	p.cancel()
+	p.hub.Close()
+	p.server.Close()
+}
+
+func (p *Playback) serve(ctx context.Context, inbound <-chan PeerMessage) {
+	var (
+		peer     *Peer
+		previous time.Time
+	)
+
+	for {
+		for peer == nil || peer.IsClose() {
+			peer = p.waitPeer(ctx)
+			if peer == nil {
+				return
+			}
+		}
+
+		rec, ok := p.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-peer.Done():
+				continue
+			}
+		}
+
+		if p.option.Speed > 0 && !previous.IsZero() {
+			delay := time.Duration(float64(rec.Time.Sub(previous)) / p.option.Speed)
+			if !sleep(ctx, delay) {
+				return
+			}
+		}
+		previous = rec.Time
+
+		switch rec.Direction {
+		case DirectionInbound:
+			if err := peer.WriteRaw(rec.Message.Type, rec.Message.Data); err != nil {
+				p.rewind()
+				peer = nil
+			}
+		case DirectionOutbound:
+			if !p.option.IgnoreOutbound && !p.waitOutbound(ctx, inbound, peer) {
+				p.rewind()
+				peer = nil
+			}
+		case DirectionClose:
+			peer.Close()
+			<-peer.Done()
+			peer = nil
+		}
+	}
+}
+
+// waitOutbound waits for a message from peer, returns false if the peer is closed before receiving.
+func (p *Playback) waitOutbound(ctx context.Context, inbound <-chan PeerMessage, peer *Peer) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-peer.Done():
+			return false
+		case msg, ok := <-inbound:
+			if !ok {
+				return false
+			}
+
+			if msg.Peer == peer {
+				return true
+			}
+		}
+	}
+}
+
+func (p *Playback) waitPeer(ctx context.Context) *Peer {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		for _, peer := range p.hub.Peers() {
+			if !peer.IsClose() {
+				return peer
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Playback) next() (Record, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cursor >= len(p.records) {
+		return Record{}, false
+	}
+
+	rec := p.records[p.cursor]
+	p.cursor++
+	return rec, true
+}
+
+func (p *Playback) rewind() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cursor > 0 {
+		p.cursor--
+	}
+}
+
+func sleep(ctx context.Context, delay time.Duration) bool {
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,469 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/logs"
+	"github.com/yanun0323/pkg/channel"
+)
+
+var (
+	// ErrPoolFull represents the error of adding a register into a pool whose sockets all reach PoolOption.MaxPerConn.
+	ErrPoolFull = errors.New("pool full")
+)
+
+// PoolOption defines settings for NewPool.
+type PoolOption struct {
+	// Option is the setting of every socket in the pool.
+	//
+	// It is shared by all sockets, so Backoff.Policy and OnEvent are called by the sockets concurrently
+	// and must be safe for concurrent use. The built-in backoff is created per socket.
+	Option
+	// Size is the number of sockets in the pool.
+	//
+	// Zero means one socket.
+	Size int
+	// MaxPerConn is the maximum number of registers assigned to a socket.
+	//
+	// Zero means no limit.
+	MaxPerConn int
+}
+
+// Pool owns multiple WebSockets to the same url and shards registers across them.
+//
+// Registers are assigned to the socket with the fewest registers. When a socket is disconnected,
+// its registers are moved to the other ready sockets. When a socket is closed permanently,
+// e.g. running out of reconnect attempts, it is replaced by a new socket and its remaining
+// registers are reassigned.
+//
+// Messages from all sockets are merged into a single subscription stream.
+type Pool struct {
+	ctx    context.Context
+	url    string
+	option PoolOption
+
+	mu        sync.Mutex
+	sockets   []*poolSocket
+	registers map[RegisterID]*poolRegister
+	nextID    atomic.Uint64
+
+	fanout   *fanout
+	shutdown chan struct{}
+	start    atomic.Bool
+	end      atomic.Bool
+	logger   logs.Logger
+}
+
+type poolSocket struct {
+	ws        *WebSocket
+	registers int
+}
+
+type poolRegister struct {
+	sidecar Sidecar
+	socket  *poolSocket
+	id      RegisterID
+}
+
+// NewPool creates a pool of websockets without connecting to the websocket.
+//
+// The pool is closed when ctx is done.
+func NewPool(ctx context.Context, url string, opts ...PoolOption) *Pool {
+	option := PoolOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+	if option.Size <= 0 {
+		option.Size = 1
+	}
+
+	logger := logs.Get(ctx).With(
+		"pool", newLogID(),
+		"url", url,
+	)
+
+	p := &Pool{
+		ctx:       ctx,
+		url:       url,
+		option:    option,
+		registers: make(map[RegisterID]*poolRegister),
+		fanout:    newFanout(option.Router, option.Metrics, logger),
+		shutdown:  make(chan struct{}),
+		logger:    logger,
+	}
+
+	for i := 0; i < option.Size; i++ {
+		p.sockets = append(p.sockets, p.newSocket())
+	}
+	context.AfterFunc(ctx, p.Close)
+
+	return p
+}
+
+func (p *Pool) newSocket() *poolSocket {
+	ws := New(p.ctx, p.url, p.option.Option)
+	messages, _ := ws.Subscribe()
+	go func() {
+		for msg := range messages {
+			p.fanout.broadcast(msg)
+			msg.Release()
+		}
+	}()
+
+	return &poolSocket{ws: ws}
+}
+
+// Start starts connecting all sockets of the pool.
+func (p *Pool) Start(ctx context.Context) error {
+	if p.start.Swap(true) {
+		return nil
+	}
+
+	p.mu.Lock()
+	sockets := make([]*poolSocket, len(p.sockets))
+	copy(sockets, p.sockets)
+	p.mu.Unlock()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	for _, s := range sockets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.startSocket(ctx, s); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// startSocket starts the socket and the goroutines rebalancing and replacing it.
+//
+// The event goroutine only signals, so slow rebalancing never fills the event channel.
+// The closing is observed with the shutdown channel of the socket, which cannot be dropped like an event.
+func (p *Pool) startSocket(ctx context.Context, s *poolSocket) error {
+	events, _ := s.ws.Events()
+	lost := make(chan struct{}, 1)
+	go func() {
+		for e := range events {
+			switch e.Type {
+			case EventDisconnected, EventGaveUp:
+				channel.TryPush(lost, struct{}{})
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			select {
+			case <-lost:
+				p.rebalance(s)
+			case <-s.ws.shutdown:
+				p.replace(s)
+				return
+			}
+		}
+	}()
+
+	return s.ws.Start(ctx)
+}
+
+// replace replaces a closed socket with a new one and reassigns its registers.
+func (p *Pool) replace(dead *poolSocket) {
+	p.mu.Lock()
+	if p.IsClose() || p.ctx.Err() != nil {
+		p.mu.Unlock()
+		return
+	}
+
+	fresh := p.newSocket()
+	for i, s := range p.sockets {
+		if s == dead {
+			p.sockets[i] = fresh
+		}
+	}
+
+	var orphans []RegisterID
+	for id, r := range p.registers {
+		if r.socket == dead {
+			r.socket = nil
+			orphans = append(orphans, id)
+		}
+	}
+	p.mu.Unlock()
+
+	p.logger.Warnf("socket closed, rebalance %d registers", len(orphans))
+	if err := p.startSocket(p.ctx, fresh); err != nil {
+		p.logger.Errorf("start replaced socket, err: %+v", err)
+	}
+
+	if p.IsClose() {
+		fresh.ws.Close()
+		return
+	}
+
+	for _, id := range orphans {
+		p.mu.Lock()
+		r, ok := p.registers[id]
+		var target *poolSocket
+		if ok {
+			target = p.pick(nil)
+			if target == nil {
+				target = fresh
+			}
+			r.socket = target
+			r.id = target.ws.AddRegister(r.sidecar)
+			target.registers++
+		}
+		p.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if err := target.ws.SendAndWait(p.ctx, r.sidecar); err != nil {
+			p.logger.Errorf("reassign register, err: %+v", err)
+		}
+	}
+}
+
+// rebalance moves the registers of a disconnected socket to the other ready sockets.
+//
+// Registers stay on the socket when no other socket is ready, and are replayed after it reconnects.
+func (p *Pool) rebalance(lost *poolSocket) {
+	ready := func(s *poolSocket) bool {
+		return s != lost && s.ws.State() == StateReady
+	}
+
+	type move struct {
+		sidecar Sidecar
+		target  *poolSocket
+	}
+
+	p.mu.Lock()
+	if p.IsClose() {
+		p.mu.Unlock()
+		return
+	}
+
+	var moves []move
+	for _, r := range p.registers {
+		if r.socket != lost {
+			continue
+		}
+
+		target := p.pick(ready)
+		if target == nil {
+			break
+		}
+
+		lost.ws.RemoveRegister(r.id)
+		lost.registers--
+		r.socket = target
+		r.id = target.ws.AddRegister(r.sidecar)
+		target.registers++
+		moves = append(moves, move{sidecar: r.sidecar, target: target})
+	}
+	p.mu.Unlock()
+
+	if len(moves) == 0 {
+		return
+	}
+
+	p.logger.Warnf("socket disconnected, move %d registers to ready sockets", len(moves))
+	for _, m := range moves {
+		if err := m.target.ws.SendAndWait(p.ctx, m.sidecar); err != nil {
+			p.logger.Errorf("move register, err: %+v", err)
+		}
+	}
+}
+
+// pick returns the live socket accepted by filter with the fewest registers and spare capacity.
+//
+// Nil filter accepts all live sockets.
+func (p *Pool) pick(filter func(*poolSocket) bool) *poolSocket {
+	var target *poolSocket
+	for _, s := range p.sockets {
+		if s.ws.IsClose() {
+			continue
+		}
+		if filter != nil && !filter(s) {
+			continue
+		}
+		if p.option.MaxPerConn > 0 && s.registers >= p.option.MaxPerConn {
+			continue
+		}
+		if target == nil || s.registers < target.registers {
+			target = s
+		}
+	}
+
+	return target
+}
+
+// AddRegister assigns the register to a socket, runs it immediately, and replays it after every reconnecting.
+//
+// A register with the same non-empty Sidecar.Name replaces the existing one on the same socket.
+func (p *Pool) AddRegister(ctx context.Context, sidecar Sidecar) (RegisterID, error) {
+	if p.IsClose() {
+		return 0, errors.Wrap(ErrConnectionClose, "pool closed")
+	}
+
+	p.mu.Lock()
+	var target *poolSocket
+	if sidecar.Name != "" {
+		for id, r := range p.registers {
+			if r.sidecar.Name == sidecar.Name && r.socket != nil {
+				target = r.socket
+				target.registers--
+				delete(p.registers, id)
+				break
+			}
+		}
+	}
+
+	if target == nil {
+		target = p.pick(nil)
+	}
+
+	if target == nil {
+		p.mu.Unlock()
+		return 0, errors.Wrapf(ErrPoolFull, "%d sockets with %d registers per connection", len(p.sockets), p.option.MaxPerConn)
+	}
+
+	id := RegisterID(p.nextID.Add(1))
+	r := &poolRegister{sidecar: sidecar, socket: target}
+	r.id = target.ws.AddRegister(sidecar)
+	target.registers++
+	p.registers[id] = r
+	p.mu.Unlock()
+
+	if err := target.ws.SendAndWait(ctx, sidecar); err != nil {
+		p.RemoveRegister(id)
+		return 0, errors.Wrap(err, "run register")
+	}
+
+	return id, nil
+}
+
+// RemoveRegister removes the register with the provided handle, returns false if it does not exist.
+func (p *Pool) RemoveRegister(id RegisterID) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.registers[id]
+	if !ok {
+		return false
+	}
+
+	delete(p.registers, id)
+	if r.socket != nil {
+		r.socket.ws.RemoveRegister(r.id)
+		r.socket.registers--
+	}
+
+	return true
+}
+
+// Sockets returns all sockets of the pool
+func (p *Pool) Sockets() []*WebSocket {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sockets := make([]*WebSocket, 0, len(p.sockets))
+	for _, s := range p.sockets {
+		sockets = append(sockets, s.ws)
+	}
+
+	return sockets
+}
+
+// Subscribe subscribes the merged messages of all sockets
+//
+// The optional SubscribeOption defines the queue capacity and overflow policy of the subscription.
+func (p *Pool) Subscribe(opts ...SubscribeOption) (<-chan Message, func()) {
+	option := SubscribeOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+
+	return p.fanout.subscribe(option)
+}
+
+// SubscribeTopic subscribes the merged messages whose routing key extracted by Option.Router equals key
+//
+// The optional SubscribeOption defines the queue capacity and overflow policy of the subscription.
+func (p *Pool) SubscribeTopic(key string, opts ...SubscribeOption) (<-chan Message, func()) {
+	option := SubscribeOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+
+	return p.fanout.subscribeTopic(key, option)
+}
+
+// Len returns the subscribers number of the pool
+func (p *Pool) Len() int {
+	return p.fanout.len()
+}
+
+// Close closes all sockets of the pool
+func (p *Pool) Close() {
+	if p.end.Swap(true) {
+		return
+	}
+
+	channel.SafeClose(p.shutdown)
+	for _, ws := range p.Sockets() {
+		ws.Close()
+	}
+	p.fanout.close()
+}
+
+// Shutdown gracefully closes all sockets of the pool concurrently, see WebSocket.Shutdown.
+func (p *Pool) Shutdown(ctx context.Context, opts ...CloseOption) error {
+	if p.end.Load() {
+		return nil
+	}
+
+	channel.SafeClose(p.shutdown)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+
+	for _, ws := range p.Sockets() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ws.Shutdown(ctx, opts...); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	p.Close()
+	return errors.Join(errs...)
+}
+
+// IsClose returns whether the pool is closed or not
+func (p *Pool) IsClose() bool {
+	return channel.IsClose(p.shutdown)
+}
@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	received := make(chan string, 10)
+	go func() {
+		for msg := range inbound {
+			received <- string(msg.Message.Data)
+			_ = msg.Peer.WriteRaw(msg.Message.Type, msg.Message.Data)
+		}
+	}()
+
+	echo := func(name string) Sidecar {
+		return Sidecar{
+			Name: name,
+			Sender: func(_ context.Context, ws *WebSocket) error {
+				return ws.WriteRaw(MessageTypeText, []byte(name))
+			},
+			Waiter: func(_ context.Context, msg Message) (bool, error) {
+				return string(msg.Data) == name, nil
+			},
+		}
+	}
+
+	pool := NewPool(ctx, url, PoolOption{Size: 2, MaxPerConn: 1})
+	defer pool.Close()
+	tester.RequireNoError(t, pool.Start(ctx))
+	waitPeers(t, hub, 2)
+
+	merged, unsubscribeMerged := pool.Subscribe()
+	defer unsubscribeMerged()
+
+	_, err := pool.AddRegister(ctx, echo("a"))
+	tester.RequireNoError(t, err)
+	tester.RequireEqual(t, "a", waitMessage(t, received))
+	tester.RequireEqual(t, "a", string(waitMessage(t, merged).Data))
+
+	_, err = pool.AddRegister(ctx, echo("b"))
+	tester.RequireNoError(t, err)
+	tester.RequireEqual(t, "b", waitMessage(t, received))
+	tester.RequireEqual(t, "b", string(waitMessage(t, merged).Data))
+
+	sockets := pool.Sockets()
+	tester.RequireEqual(t, 1, len(sockets[0].Registers()))
+	tester.RequireEqual(t, 1, len(sockets[1].Registers()))
+
+	_, err = pool.AddRegister(ctx, echo("c"))
+	tester.RequireTrue(t, errors.Is(err, ErrPoolFull))
+
+	dead := sockets[0]
+	dead.Close()
+
+	tester.RequireEqual(t, "a", waitMessage(t, received))
+	tester.RequireEqual(t, "a", string(waitMessage(t, merged).Data))
+	tester.RequireFalse(t, pool.Sockets()[0] == dead)
+}
+
+func TestPoolRebalance(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	go func() {
+		for msg := range inbound {
+			_ = msg.Peer.WriteRaw(msg.Message.Type, msg.Message.Data)
+		}
+	}()
+
+	pool := NewPool(ctx, url, PoolOption{Size: 2})
+	defer pool.Close()
+	tester.RequireNoError(t, pool.Start(ctx))
+
+	_, err := pool.AddRegister(ctx, Sidecar{
+		Name: "a",
+		Sender: func(_ context.Context, ws *WebSocket) error {
+			return ws.WriteRaw(MessageTypeText, []byte("a"))
+		},
+		Waiter: func(_ context.Context, msg Message) (bool, error) {
+			return string(msg.Data) == "a", nil
+		},
+	})
+	tester.RequireNoError(t, err)
+
+	lost, other := pool.Sockets()[0], pool.Sockets()[1]
+	if len(lost.Registers()) == 0 {
+		lost, other = other, lost
+	}
+	lost.Reconnect()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for len(other.Registers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tester.RequireEqual(t, 1, len(other.Registers()))
+	tester.RequireEqual(t, 0, len(lost.Registers()))
+}
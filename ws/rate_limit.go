@@ -0,0 +1,191 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yanun0323/errors"
+)
+
+var (
+	// ErrRateLimited represents the error of exceeding the rate limit in RateLimitFailFast mode.
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// MessageClass represents the class of outbound messages limited by RateLimitOption.
+type MessageClass int
+
+const (
+	// MessageClassWrite is every message written by WriteJSON, WriteRaw, WriteMessage and the write queue.
+	MessageClassWrite MessageClass = iota + 1
+	// MessageClassRequest is every Sidecar.Sender run by SendAndWait and every Call.
+	MessageClassRequest
+	// MessageClassRegister is every Sidecar.Sender run by the register replay after connecting/reconnecting.
+	MessageClassRegister
+)
+
+func (c MessageClass) String() string {
+	switch c {
+	case MessageClassWrite:
+		return "Write"
+	case MessageClassRequest:
+		return "Request"
+	case MessageClassRegister:
+		return "Register"
+	default:
+		return fmt.Sprintf("Unknown(%d)", c)
+	}
+}
+
+// RateLimitMode defines how a limited message waits for the token.
+type RateLimitMode int
+
+const (
+	// RateLimitBlock waits until a token is available or the context is done. It is the default mode.
+	RateLimitBlock RateLimitMode = iota
+	// RateLimitFailFast returns ErrRateLimited immediately when no token is available.
+	RateLimitFailFast
+)
+
+func (m RateLimitMode) String() string {
+	switch m {
+	case RateLimitBlock:
+		return "Block"
+	case RateLimitFailFast:
+		return "FailFast"
+	default:
+		return fmt.Sprintf("Unknown(%d)", m)
+	}
+}
+
+// RateLimit defines a token bucket.
+type RateLimit struct {
+	// Rate is the number of tokens refilled per second.
+	//
+	// Zero means no limit.
+	Rate float64
+	// Burst is the capacity of the bucket.
+	//
+	// Zero means using the ceiling of Rate, at least 1.
+	Burst int
+	// Mode defines how a limited message waits for the token.
+	Mode RateLimitMode
+}
+
+// RateLimitOption defines the token buckets of outbound messages per MessageClass.
+//
+// Heartbeats and close frames are never limited.
+// Messages written by a Sidecar.Sender take a MessageClassWrite token besides the token of the Sender,
+// so Write is the limit of the wire, and Request and Register pace how often the senders run.
+type RateLimitOption struct {
+	Write    RateLimit
+	Request  RateLimit
+	Register RateLimit
+}
+
+// limiter is a token bucket.
+type limiter struct {
+	option RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(option RateLimit) *limiter {
+	if option.Rate <= 0 {
+		return nil
+	}
+	if option.Burst <= 0 {
+		option.Burst = max(int(option.Rate+0.999999), 1)
+	}
+
+	return &limiter{
+		option: option,
+		tokens: float64(option.Burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token, returns the delay until the token is available.
+//
+// Without waiting, the token is not taken if it is not available yet.
+func (l *limiter) reserve(wait bool) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.option.Rate, float64(l.option.Burst))
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+
+	delay := time.Duration((1 - l.tokens) / l.option.Rate * float64(time.Second))
+	if wait {
+		l.tokens--
+	}
+
+	return delay
+}
+
+// wait takes a token, blocks until it is available in RateLimitBlock mode.
+func (l *limiter) wait(ctx context.Context, done <-chan struct{}) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.option.Mode == RateLimitFailFast {
+		if delay := l.reserve(false); delay > 0 {
+			return errors.Wrapf(ErrRateLimited, "retry after %s", delay)
+		}
+		return nil
+	}
+
+	delay := l.reserve(true)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.refund()
+		return errors.Wrap(ctx.Err(), "wait for rate limit")
+	case <-done:
+		l.refund()
+		return errors.Wrap(ErrConnectionClose, "websocket closed")
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refund returns the token taken by an abandoned wait.
+func (l *limiter) refund() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens = min(l.tokens+1, float64(l.option.Burst))
+}
+
+// limiters holds the token buckets of every MessageClass.
+type limiters map[MessageClass]*limiter
+
+func newLimiters(option RateLimitOption) limiters {
+	return limiters{
+		MessageClassWrite:    newLimiter(option.Write),
+		MessageClassRequest:  newLimiter(option.Request),
+		MessageClassRegister: newLimiter(option.Register),
+	}
+}
+
+// limit takes a token of the class.
+func (ws *WebSocket) limit(ctx context.Context, class MessageClass) error {
+	return ws.limiters[class].wait(ctx, ws.shutdown)
+}
@@ -0,0 +1,64 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestLimiterFailFast(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 10, Burst: 2, Mode: RateLimitFailFast})
+	done := make(chan struct{})
+
+	tester.RequireNoError(t, l.wait(t.Context(), done))
+	tester.RequireNoError(t, l.wait(t.Context(), done))
+	tester.RequireTrue(t, errors.Is(l.wait(t.Context(), done), ErrRateLimited))
+
+	time.Sleep(150 * time.Millisecond)
+	tester.RequireNoError(t, l.wait(t.Context(), done))
+	tester.RequireTrue(t, newLimiter(RateLimit{}) == nil)
+}
+
+func TestLimiterRefund(t *testing.T) {
+	l := newLimiter(RateLimit{Rate: 1, Burst: 1})
+	done := make(chan struct{})
+
+	tester.RequireNoError(t, l.wait(t.Context(), done))
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	tester.RequireTrue(t, errors.Is(l.wait(ctx, done), context.DeadlineExceeded))
+
+	delay := l.reserve(false)
+	tester.RequireTrue(t, delay > 0 && delay <= time.Second)
+}
+
+func TestRateLimitWrite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	client := New(ctx, url, Option{
+		RateLimit: RateLimitOption{
+			Write: RateLimit{Rate: 20, Burst: 1},
+		},
+	})
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	start := time.Now()
+	for range 5 {
+		tester.RequireNoError(t, client.WriteRaw(MessageTypeText, []byte("limited")))
+	}
+	tester.RequireTrue(t, time.Since(start) >= 190*time.Millisecond)
+
+	for range 5 {
+		tester.RequireEqual(t, "limited", string(waitMessage(t, inbound).Message.Data))
+	}
+}
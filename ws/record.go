@@ -0,0 +1,268 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yanun0323/errors"
+)
+
+// Direction represents the direction of a recorded message.
+type Direction uint8
+
+const (
+	// DirectionInbound represents a message received from the peer.
+	DirectionInbound Direction = iota + 1
+	// DirectionOutbound represents a message written to the peer.
+	DirectionOutbound
+	// DirectionClose represents the connection being closed, it carries no message.
+	DirectionClose
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "in"
+	case DirectionOutbound:
+		return "out"
+	case DirectionClose:
+		return "close"
+	default:
+		return fmt.Sprintf("unknown(%d)", d)
+	}
+}
+
+// RecordFormat represents the encoding of recorded messages.
+type RecordFormat int
+
+const (
+	// RecordFormatJSONLines encodes every record as a JSON object in a line.
+	RecordFormatJSONLines RecordFormat = iota
+	// RecordFormatBinary encodes every record as a compact length-prefixed binary frame.
+	RecordFormatBinary
+)
+
+// Record is a message captured by Recorder.
+type Record struct {
+	Time      time.Time
+	Direction Direction
+	Message   Message
+}
+
+// recordJSON is the JSON Lines encoding of Record.
+//
+// Text messages are stored in text, unless they are invalid UTF-8 which a JSON string cannot keep byte-exact,
+// then they are stored in binary as base64 like binary messages.
+type recordJSON struct {
+	Time      time.Time   `json:"time"`
+	Direction string      `json:"dir"`
+	Type      MessageType `json:"type,omitempty"`
+	Text      string      `json:"text,omitempty"`
+	Binary    []byte      `json:"binary,omitempty"`
+}
+
+func (r Record) MarshalJSON() ([]byte, error) {
+	v := recordJSON{
+		Time:      r.Time,
+		Direction: r.Direction.String(),
+		Type:      r.Message.Type,
+	}
+
+	if r.Message.Type == MessageTypeText && utf8.Valid(r.Message.Data) {
+		v.Text = string(r.Message.Data)
+	} else {
+		v.Binary = r.Message.Data
+	}
+
+	return json.Marshal(v)
+}
+
+func (r *Record) UnmarshalJSON(data []byte) error {
+	var v recordJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	r.Time = v.Time
+	switch v.Direction {
+	case DirectionInbound.String():
+		r.Direction = DirectionInbound
+	case DirectionOutbound.String():
+		r.Direction = DirectionOutbound
+	case DirectionClose.String():
+		r.Direction = DirectionClose
+	default:
+		return errors.Errorf("unknown direction (%s)", v.Direction)
+	}
+
+	r.Message = Message{Type: v.Type}
+	if v.Binary == nil {
+		r.Message.Data = []byte(v.Text)
+	} else {
+		r.Message.Data = v.Binary
+	}
+
+	return nil
+}
+
+// Recorder captures all inbound and outbound messages of connections with timestamps.
+//
+// Set it into Option.Recorder to record a WebSocket.
+type Recorder struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+	format RecordFormat
+	err    error
+}
+
+// NewRecorder creates a Recorder writing records into w.
+func NewRecorder(w io.Writer, format RecordFormat) *Recorder {
+	r := &Recorder{
+		w:      bufio.NewWriter(w),
+		format: format,
+	}
+
+	if c, ok := w.(io.Closer); ok {
+		r.closer = c
+	}
+
+	return r
+}
+
+// CreateRecorder creates a Recorder writing records into the file of path.
+func CreateRecorder(path string, format RecordFormat) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "create record file (%s)", path)
+	}
+
+	return NewRecorder(f, format), nil
+}
+
+// Record writes a record, the first error is kept and returned by all later calls.
+func (r *Recorder) Record(dir Direction, msg Message) error {
+	if r == nil {
+		return nil
+	}
+
+	rec := Record{Time: time.Now(), Direction: dir, Message: msg}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	switch r.format {
+	case RecordFormatBinary:
+		var header [14]byte
+		binary.BigEndian.PutUint64(header[0:8], uint64(rec.Time.UnixNano()))
+		header[8] = byte(rec.Direction)
+		header[9] = byte(rec.Message.Type)
+		binary.BigEndian.PutUint32(header[10:14], uint32(len(rec.Message.Data)))
+		if _, err := r.w.Write(header[:]); err != nil {
+			r.err = errors.Wrap(err, "write record header")
+			return r.err
+		}
+		if _, err := r.w.Write(rec.Message.Data); err != nil {
+			r.err = errors.Wrap(err, "write record data")
+			return r.err
+		}
+	default:
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return errors.Wrap(err, "marshal record")
+		}
+		data = append(data, '\n')
+		if _, err := r.w.Write(data); err != nil {
+			r.err = errors.Wrap(err, "write record")
+			return r.err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes the buffered records into the underlying writer.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return errors.Wrap(r.w.Flush(), "flush records")
+}
+
+// Close flushes the buffered records and closes the underlying writer if it is an io.Closer.
+func (r *Recorder) Close() error {
+	if err := r.Flush(); err != nil {
+		return err
+	}
+
+	if r.closer != nil {
+		return errors.Wrap(r.closer.Close(), "close recorder")
+	}
+
+	return nil
+}
+
+// ReadRecords reads all records from rd.
+func ReadRecords(rd io.Reader, format RecordFormat) ([]Record, error) {
+	var records []Record
+	br := bufio.NewReader(rd)
+
+	switch format {
+	case RecordFormatBinary:
+		for {
+			var header [14]byte
+			if _, err := io.ReadFull(br, header[:]); err != nil {
+				if errors.Is(err, io.EOF) {
+					return records, nil
+				}
+				return nil, errors.Wrap(err, "read record header")
+			}
+
+			data := make([]byte, binary.BigEndian.Uint32(header[10:14]))
+			if _, err := io.ReadFull(br, data); err != nil {
+				return nil, errors.Wrap(err, "read record data")
+			}
+
+			records = append(records, Record{
+				Time:      time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+				Direction: Direction(header[8]),
+				Message:   Message{Type: MessageType(header[9]), Data: data},
+			})
+		}
+	default:
+		dec := json.NewDecoder(br)
+		for {
+			var rec Record
+			if err := dec.Decode(&rec); err != nil {
+				if errors.Is(err, io.EOF) {
+					return records, nil
+				}
+				return nil, errors.Wrap(err, "decode record")
+			}
+
+			records = append(records, rec)
+		}
+	}
+}
+
+// LoadRecords reads all records from the file of path.
+func LoadRecords(path string, format RecordFormat) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open record file (%s)", path)
+	}
+	defer f.Close()
+
+	return ReadRecords(f, format)
+}
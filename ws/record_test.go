@@ -0,0 +1,95 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestRecordFormat(t *testing.T) {
+	for _, format := range []RecordFormat{RecordFormatJSONLines, RecordFormatBinary} {
+		buf := &bytes.Buffer{}
+		r := NewRecorder(buf, format)
+		tester.RequireNoError(t, r.Record(DirectionOutbound, textMessage(`{"op":"subscribe"}`)))
+		tester.RequireNoError(t, r.Record(DirectionInbound, Message{Type: MessageTypeBinary, Data: []byte{0, 1, 2}}))
+		tester.RequireNoError(t, r.Record(DirectionClose, Message{}))
+		tester.RequireNoError(t, r.Close())
+
+		records, err := ReadRecords(buf, format)
+		tester.RequireNoError(t, err)
+		tester.RequireEqual(t, 3, len(records))
+		tester.RequireEqual(t, DirectionOutbound, records[0].Direction)
+		tester.RequireEqual(t, `{"op":"subscribe"}`, string(records[0].Message.Data))
+		tester.RequireEqual(t, MessageTypeBinary, records[1].Message.Type)
+		tester.RequireTrue(t, bytes.Equal([]byte{0, 1, 2}, records[1].Message.Data))
+		tester.RequireEqual(t, DirectionClose, records[2].Direction)
+		tester.RequireFalse(t, records[2].Time.IsZero())
+	}
+}
+
+func TestRecordInvalidUTF8(t *testing.T) {
+	data := []byte{'o', 'k', 0xff, 0xfe}
+
+	buf := &bytes.Buffer{}
+	r := NewRecorder(buf, RecordFormatJSONLines)
+	tester.RequireNoError(t, r.Record(DirectionInbound, Message{Type: MessageTypeText, Data: data}))
+	tester.RequireNoError(t, r.Record(DirectionInbound, textMessage("valid")))
+	tester.RequireNoError(t, r.Close())
+	tester.RequireTrue(t, bytes.Contains(buf.Bytes(), []byte(`"text":"valid"`)))
+
+	records, err := ReadRecords(buf, RecordFormatJSONLines)
+	tester.RequireNoError(t, err)
+	tester.RequireEqual(t, 2, len(records))
+	tester.RequireEqual(t, MessageTypeText, records[0].Message.Type)
+	tester.RequireTrue(t, bytes.Equal(data, records[0].Message.Data))
+	tester.RequireEqual(t, "valid", string(records[1].Message.Data))
+}
+
+func TestPlayback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	now := time.Now()
+	record := func(dir Direction, data string) Record {
+		now = now.Add(time.Millisecond)
+		return Record{Time: now, Direction: dir, Message: textMessage(data)}
+	}
+
+	playback := NewPlayback(ctx, []Record{
+		record(DirectionOutbound, `{"op":"subscribe"}`),
+		record(DirectionInbound, `{"op":"ack"}`),
+		record(DirectionInbound, `{"data":1}`),
+		record(DirectionClose, ""),
+		record(DirectionOutbound, `{"op":"subscribe"}`),
+		record(DirectionInbound, `{"op":"ack"}`),
+		record(DirectionInbound, `{"data":2}`),
+	}, PlaybackOption{Speed: 10})
+	defer playback.Close()
+
+	client := New(ctx, playback.URL(), Option{
+		Backoff: BackoffOption{Min: time.Millisecond},
+		Router: func(msg Message) (string, bool) {
+			return "data", bytes.Contains(msg.Data, []byte("data"))
+		},
+	})
+	defer client.Close()
+
+	messages, unsubscribe := client.SubscribeTopic("data")
+	defer unsubscribe()
+
+	tester.RequireNoError(t, client.Start(ctx, Sidecar{
+		Sender: func(_ context.Context, ws *WebSocket) error {
+			return ws.WriteJSON(map[string]string{"op": "subscribe"})
+		},
+		Waiter: func(_ context.Context, msg Message) (bool, error) {
+			return string(msg.Data) == `{"op":"ack"}`, nil
+		},
+	}))
+
+	tester.RequireEqual(t, `{"data":1}`, string(waitMessage(t, messages).Data))
+	tester.RequireEqual(t, `{"data":2}`, string(waitMessage(t, messages).Data))
+	tester.RequireTrue(t, playback.Done())
+}
@@ -0,0 +1,184 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RegisterID is the handle of a register added into WebSocket.
+type RegisterID uint64
+
+// RegisterOption defines how registers are replayed after every connecting/reconnecting.
+type RegisterOption struct {
+	// Concurrent runs all registers concurrently instead of one by one in the added order.
+	Concurrent bool
+	// Timeout is the overall deadline of replaying all registers.
+	//
+	// Zero means no overall deadline, every register is still limited by its own Sidecar.Timeout.
+	Timeout time.Duration
+}
+
+// RegisterFailure is a register failed in a replay.
+type RegisterFailure struct {
+	ID   RegisterID
+	Name string
+	Err  error
+}
+
+// RegisterError reports the registers failed in a replay.
+type RegisterError struct {
+	Failures []RegisterFailure
+}
+
+func (e *RegisterError) Error() string {
+	msgs := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		if f.Name != "" {
+			msgs = append(msgs, fmt.Sprintf("register (%s): %v", f.Name, f.Err))
+		} else {
+			msgs = append(msgs, fmt.Sprintf("register (%d): %v", f.ID, f.Err))
+		}
+	}
+
+	return strings.Join(msgs, "; ")
+}
+
+func (e *RegisterError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+
+	return errs
+}
+
+type register struct {
+	id      RegisterID
+	sidecar Sidecar
+}
+
+// AddRegister adds a register which is invoked after every websocket connecting/reconnecting.
+//
+// A register with the same non-empty Sidecar.Name replaces the existing one in place.
+// It does not run the register immediately, use SendAndWait with appendIntoRegister instead.
+func (ws *WebSocket) AddRegister(sidecar Sidecar) RegisterID {
+	ws.registersLock.Lock()
+	defer ws.registersLock.Unlock()
+
+	return ws.addRegister(sidecar)
+}
+
+func (ws *WebSocket) addRegister(sidecar Sidecar) RegisterID {
+	id := RegisterID(ws.nextID.Add(1))
+	if sidecar.Name != "" {
+		for i, r := range ws.registers {
+			if r.sidecar.Name == sidecar.Name {
+				ws.registers[i] = register{id: id, sidecar: sidecar}
+				return id
+			}
+		}
+	}
+
+	ws.registers = append(ws.registers, register{id: id, sidecar: sidecar})
+	return id
+}
+
+// RemoveRegister removes the register with the provided handle, returns false if it does not exist.
+func (ws *WebSocket) RemoveRegister(id RegisterID) bool {
+	ws.registersLock.Lock()
+	defer ws.registersLock.Unlock()
+
+	for i, r := range ws.registers {
+		if r.id == id {
+			ws.registers = append(ws.registers[:i], ws.registers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// RemoveRegisterByName removes the register with the provided name, returns false if it does not exist.
+func (ws *WebSocket) RemoveRegisterByName(name string) bool {
+	ws.registersLock.Lock()
+	defer ws.registersLock.Unlock()
+
+	for i, r := range ws.registers {
+		if r.sidecar.Name != "" && r.sidecar.Name == name {
+			ws.registers = append(ws.registers[:i], ws.registers[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Registers returns the handles of all registers in the replay order.
+func (ws *WebSocket) Registers() []RegisterID {
+	ws.registersLock.RLock()
+	defer ws.registersLock.RUnlock()
+
+	ids := make([]RegisterID, 0, len(ws.registers))
+	for _, r := range ws.registers {
+		ids = append(ids, r.id)
+	}
+
+	return ids
+}
+
+// replayRegisters runs all registers, returns *RegisterError if any of them fails.
+func (ws *WebSocket) replayRegisters(ctx context.Context) error {
+	ws.registersLock.RLock()
+	registers := make([]register, len(ws.registers))
+	copy(registers, ws.registers)
+	ws.registersLock.RUnlock()
+
+	if len(registers) == 0 {
+		return nil
+	}
+
+	option := ws.option.Register
+	if option.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, option.Timeout)
+		defer cancel()
+	}
+
+	if !option.Concurrent {
+		for _, r := range registers {
+			if err := ws.sendAndWait(ctx, r.sidecar, MessageClassRegister); err != nil {
+				return &RegisterError{Failures: []RegisterFailure{{ID: r.id, Name: r.sidecar.Name, Err: err}}}
+			}
+		}
+
+		return nil
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []RegisterFailure
+	)
+
+	for _, r := range registers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ws.sendAndWait(ctx, r.sidecar, MessageClassRegister); err != nil {
+				mu.Lock()
+				failures = append(failures, RegisterFailure{ID: r.id, Name: r.sidecar.Name, Err: err})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(failures) != 0 {
+		return &RegisterError{Failures: failures}
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestRegisterManagement(t *testing.T) {
+	ws := New(t.Context(), "")
+
+	a := ws.AddRegister(Sidecar{Name: "a"})
+	b := ws.AddRegister(Sidecar{Name: "b"})
+	anonymous := ws.AddRegister(Sidecar{})
+	replaced := ws.AddRegister(Sidecar{Name: "a", Timeout: time.Second})
+
+	ids := ws.Registers()
+	tester.RequireEqual(t, 3, len(ids))
+	tester.RequireEqual(t, replaced, ids[0])
+	tester.RequireEqual(t, b, ids[1])
+	tester.RequireEqual(t, anonymous, ids[2])
+
+	tester.RequireFalse(t, ws.RemoveRegister(a))
+	tester.RequireTrue(t, ws.RemoveRegister(anonymous))
+	tester.RequireTrue(t, ws.RemoveRegisterByName("b"))
+	tester.RequireEqual(t, 1, len(ws.Registers()))
+}
+
+func TestRegisterConcurrentFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	go func() {
+		for msg := range inbound {
+			_ = msg.Peer.WriteRaw(msg.Message.Type, msg.Message.Data)
+		}
+	}()
+
+	echo := func(name string, failure error) Sidecar {
+		return Sidecar{
+			Name: name,
+			Sender: func(_ context.Context, ws *WebSocket) error {
+				return ws.WriteRaw(MessageTypeText, []byte(name))
+			},
+			Waiter: func(_ context.Context, msg Message) (bool, error) {
+				if string(msg.Data) != name {
+					return false, nil
+				}
+				return true, failure
+			},
+		}
+	}
+
+	client := New(ctx, url, Option{
+		Backoff:  BackoffOption{Min: time.Millisecond, MaxAttempts: 1},
+		Register: RegisterOption{Concurrent: true, Timeout: time.Second},
+	})
+	defer client.Close()
+
+	events, unsubscribeEvents := client.Events()
+	defer unsubscribeEvents()
+
+	err := client.Start(ctx, echo("good", nil), echo("bad", errors.New("rejected")), echo("fine", nil))
+	tester.RequireTrue(t, errors.Is(err, ErrReconnectExhausted))
+
+	for e := range events {
+		if e.Type != EventRegisterFailed {
+			continue
+		}
+
+		var registerErr *RegisterError
+		tester.RequireTrue(t, errors.As(e.Err, &registerErr))
+		tester.RequireEqual(t, 1, len(registerErr.Failures))
+		tester.RequireEqual(t, "bad", registerErr.Failures[0].Name)
+		return
+	}
+
+	t.Fatal("no register failed event")
+}
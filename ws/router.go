@@ -0,0 +1,54 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Router extracts the routing key from a message for topic subscriptions.
+//
+// Returns false when the message has no routing key, so it will only be
+// delivered to the subscribers which subscribe all messages.
+type Router func(Message) (key string, ok bool)
+
+// JSONRouter returns a Router which extracts the routing key from the JSON
+// field of the message data with the provided path.
+//
+// e.g. JSONRouter("arg", "channel") extracts "trades" from {"arg":{"channel":"trades"}}
+func JSONRouter(path ...string) Router {
+	return func(msg Message) (string, bool) {
+		if len(path) == 0 {
+			return "", false
+		}
+
+		raw := json.RawMessage(msg.Data)
+		for _, field := range path {
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(raw, &obj); err != nil {
+				return "", false
+			}
+
+			value, ok := obj[field]
+			if !ok {
+				return "", false
+			}
+
+			raw = value
+		}
+
+		raw = bytes.TrimSpace(raw)
+		if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+			return "", false
+		}
+
+		if raw[0] == '"' {
+			var key string
+			if err := json.Unmarshal(raw, &key); err != nil {
+				return "", false
+			}
+			return key, true
+		}
+
+		return string(raw), true
+	}
+}
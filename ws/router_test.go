@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestJSONRouter(t *testing.T) {
+	router := JSONRouter("arg", "channel")
+
+	key, ok := router(Message{Data: []byte(`{"arg":{"channel":"trades"},"data":[]}`)})
+	tester.RequireTrue(t, ok)
+	tester.RequireEqual(t, "trades", key)
+
+	key, ok = JSONRouter("id")(Message{Data: []byte(`{"id":123}`)})
+	tester.RequireTrue(t, ok)
+	tester.RequireEqual(t, "123", key)
+
+	_, ok = router(Message{Data: []byte(`{"arg":{}}`)})
+	tester.RequireFalse(t, ok)
+
+	_, ok = router(Message{Data: []byte(`not json`)})
+	tester.RequireFalse(t, ok)
+}
+
+func TestSubscribeTopic(t *testing.T) {
+	ws := New(t.Context(), "", Option{Router: JSONRouter("channel")})
+
+	trades, unsubscribeTrades := ws.SubscribeTopic("trades")
+	defer unsubscribeTrades()
+
+	all, unsubscribeAll := ws.Subscribe()
+	defer unsubscribeAll()
+
+	ws.broadcast(Message{Type: MessageTypeText, Data: []byte(`{"channel":"books"}`)})
+	ws.broadcast(Message{Type: MessageTypeText, Data: []byte(`{"channel":"trades"}`)})
+
+	tester.RequireEqual(t, `{"channel":"trades"}`, string(waitMessage(t, trades).Data))
+	tester.RequireEqual(t, 0, len(trades))
+	tester.RequireEqual(t, 2, len(all))
+	tester.RequireEqual(t, 2, ws.Len())
+}
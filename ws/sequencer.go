@@ -0,0 +1,301 @@
+package ws
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Sequence extracts the stream key and the sequence number from a message.
+//
+// Returns false when the message is not sequenced, so it is delivered without checking.
+type Sequence func(Message) (key string, seq uint64, ok bool)
+
+// JSONSequence returns a Sequence which extracts the stream key with key and the sequence number with seq.
+//
+// Nil key means all messages belong to the same stream.
+//
+// e.g. JSONSequence(JSONRouter("symbol"), JSONRouter("seq")) extracts ("BTC", 42) from {"symbol":"BTC","seq":42}
+func JSONSequence(key Router, seq Router) Sequence {
+	return func(msg Message) (string, uint64, bool) {
+		value, ok := seq(msg)
+		if !ok {
+			return "", 0, false
+		}
+
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return "", 0, false
+		}
+
+		if key == nil {
+			return "", n, true
+		}
+
+		k, ok := key(msg)
+		if !ok {
+			return "", 0, false
+		}
+
+		return k, n, true
+	}
+}
+
+// SequenceIssue represents the type of SequenceEvent.
+type SequenceIssue int
+
+const (
+	// SequenceGap means the frames between Expected and Got are missing, Got is delivered.
+	SequenceGap SequenceIssue = iota + 1
+	// SequenceDuplicate means Got is delivered or skipped already, the frame is dropped.
+	SequenceDuplicate
+	// SequenceReordered means Got arrives after frames with greater sequence numbers, and fills the gap within the window.
+	SequenceReordered
+)
+
+func (i SequenceIssue) String() string {
+	switch i {
+	case SequenceGap:
+		return "Gap"
+	case SequenceDuplicate:
+		return "Duplicate"
+	case SequenceReordered:
+		return "Reordered"
+	default:
+		return fmt.Sprintf("Unknown(%d)", i)
+	}
+}
+
+// SequenceEvent reports a gap, duplicate or reordered frame of a stream.
+type SequenceEvent struct {
+	Issue SequenceIssue
+	Key   string
+	// Expected is the next sequence number expected by the stream.
+	Expected uint64
+	// Got is the sequence number of the frame.
+	Got uint64
+	// Reconnected means the frame is the first one of the stream after reconnecting.
+	Reconnected bool
+}
+
+func (e SequenceEvent) String() string {
+	return fmt.Sprintf("%s (%s): expected %d, got %d", e.Issue, e.Key, e.Expected, e.Got)
+}
+
+// SequencerOption defines how WebSocket checks the sequence numbers of messages.
+type SequencerOption struct {
+	// Sequence extracts the stream key and the sequence number from every message.
+	//
+	// Nil disables the sequencer.
+	Sequence Sequence
+	// Window is the maximum number of frames buffered per stream to wait for the missing ones.
+	//
+	// Zero means no buffering, a gap is reported as soon as a frame is ahead of the expected one.
+	Window int
+	// OnIssue is invoked synchronously with every gap, duplicate and reordered frame, e.g. to trigger a snapshot resync.
+	//
+	// It is invoked outside the lock of the sequencer, so it may call WebSocket.ResetSequence.
+	OnIssue func(SequenceEvent)
+	// ResetOnReconnect forgets the sequence numbers after reconnecting, the first frame of every stream is accepted.
+	//
+	// By default, the sequence numbers resume after reconnecting, so the gap during the disconnection is reported.
+	ResetOnReconnect bool
+}
+
+type sequenceStream struct {
+	next        uint64
+	buffer      map[uint64]Message
+	reconnected bool
+}
+
+// sequencer checks and reorders the sequenced messages of all streams.
+type sequencer struct {
+	option SequencerOption
+
+	mu      sync.Mutex
+	streams map[string]*sequenceStream
+	// issues collects the events reported under mu, they are passed to OnIssue after unlocking.
+	issues []SequenceEvent
+}
+
+func newSequencer(option SequencerOption) *sequencer {
+	if option.Sequence == nil {
+		return nil
+	}
+
+	return &sequencer{
+		option:  option,
+		streams: make(map[string]*sequenceStream),
+	}
+}
+
+// push checks msg and returns the messages ready to be delivered in order.
+func (s *sequencer) push(msg Message) []Message {
+	if s == nil {
+		return []Message{msg}
+	}
+
+	key, seq, ok := s.option.Sequence(msg)
+	if !ok {
+		return []Message{msg}
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	stream, ok := s.streams[key]
+	if !ok {
+		stream = &sequenceStream{buffer: make(map[uint64]Message)}
+		s.streams[key] = stream
+	}
+
+	if stream.next == 0 {
+		stream.next = seq + 1
+		stream.reconnected = false
+		return []Message{msg}
+	}
+
+	switch {
+	case seq == stream.next:
+		if len(stream.buffer) != 0 {
+			s.report(SequenceEvent{Issue: SequenceReordered, Key: key, Expected: stream.next, Got: seq})
+		}
+		stream.next++
+		stream.reconnected = false
+		return s.flush(key, stream, []Message{msg})
+	case seq < stream.next:
+		s.report(SequenceEvent{Issue: SequenceDuplicate, Key: key, Expected: stream.next, Got: seq})
+		msg.Release()
+		return nil
+	}
+
+	if _, exist := stream.buffer[seq]; exist {
+		s.report(SequenceEvent{Issue: SequenceDuplicate, Key: key, Expected: stream.next, Got: seq})
+		msg.Release()
+		return nil
+	}
+
+	if len(stream.buffer) < s.option.Window {
+		stream.buffer[seq] = msg
+		return nil
+	}
+
+	// the window is full, skip the missing frames to the oldest one
+	if len(stream.buffer) == 0 || seq < slices.Min(slices.Collect(maps.Keys(stream.buffer))) {
+		s.report(SequenceEvent{Issue: SequenceGap, Key: key, Expected: stream.next, Got: seq, Reconnected: stream.reconnected})
+		stream.reconnected = false
+		stream.next = seq + 1
+		return s.flush(key, stream, []Message{msg})
+	}
+
+	ready := s.skip(key, stream)
+	if seq == stream.next {
+		stream.next++
+		return s.flush(key, stream, append(ready, msg))
+	}
+
+	stream.buffer[seq] = msg
+	return ready
+}
+
+// skip reports the gap before the oldest buffered frame and delivers the frames from it.
+func (s *sequencer) skip(key string, stream *sequenceStream) []Message {
+	oldest := slices.Min(slices.Collect(maps.Keys(stream.buffer)))
+	s.report(SequenceEvent{Issue: SequenceGap, Key: key, Expected: stream.next, Got: oldest, Reconnected: stream.reconnected})
+	stream.reconnected = false
+
+	msg := stream.buffer[oldest]
+	delete(stream.buffer, oldest)
+	stream.next = oldest + 1
+
+	return s.flush(key, stream, []Message{msg})
+}
+
+// flush appends the buffered frames following the expected sequence number.
+func (s *sequencer) flush(key string, stream *sequenceStream, ready []Message) []Message {
+	for {
+		msg, ok := stream.buffer[stream.next]
+		if !ok {
+			return ready
+		}
+
+		delete(stream.buffer, stream.next)
+		ready = append(ready, msg)
+		stream.next++
+	}
+}
+
+// reconnect handles a new connection, returns the buffered frames which should be delivered before it.
+func (s *sequencer) reconnect() []Message {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.unlock()
+
+	var ready []Message
+	for key, stream := range s.streams {
+		for len(stream.buffer) != 0 {
+			ready = append(ready, s.skip(key, stream)...)
+		}
+
+		if s.option.ResetOnReconnect {
+			delete(s.streams, key)
+		} else {
+			stream.reconnected = true
+		}
+	}
+
+	return ready
+}
+
+// reset sets the next expected sequence number of the stream and drops its buffered frames.
+func (s *sequencer) reset(key string, next uint64) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stream, ok := s.streams[key]; ok {
+		for _, msg := range stream.buffer {
+			msg.Release()
+		}
+	}
+
+	if next == 0 {
+		delete(s.streams, key)
+		return
+	}
+
+	s.streams[key] = &sequenceStream{next: next, buffer: make(map[uint64]Message)}
+}
+
+// report collects e for OnIssue, it must be called with mu held.
+func (s *sequencer) report(e SequenceEvent) {
+	if s.option.OnIssue != nil {
+		s.issues = append(s.issues, e)
+	}
+}
+
+// unlock unlocks mu and invokes OnIssue with the collected events, so OnIssue may call ResetSequence.
+func (s *sequencer) unlock() {
+	issues := s.issues
+	s.issues = nil
+	s.mu.Unlock()
+
+	for _, e := range issues {
+		s.option.OnIssue(e)
+	}
+}
+
+// ResetSequence sets the next expected sequence number of the stream, e.g. after resyncing a snapshot.
+//
+// Zero next forgets the stream, so its next frame is accepted. It is a no-op without Option.Sequencer.
+func (ws *WebSocket) ResetSequence(key string, next uint64) {
+	ws.sequencer.reset(key, next)
+}
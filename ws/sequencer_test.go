@@ -0,0 +1,153 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func seqMessage(key string, seq uint64) Message {
+	return textMessage(fmt.Sprintf(`{"key":%q,"seq":%d}`, key, seq))
+}
+
+func TestSequencer(t *testing.T) {
+	var issues []SequenceEvent
+	s := newSequencer(SequencerOption{
+		Sequence: JSONSequence(JSONRouter("key"), JSONRouter("seq")),
+		Window:   2,
+		OnIssue:  func(e SequenceEvent) { issues = append(issues, e) },
+	})
+
+	delivered := func(msgs []Message) []uint64 {
+		var seqs []uint64
+		for _, msg := range msgs {
+			_, seq, _ := s.option.Sequence(msg)
+			seqs = append(seqs, seq)
+		}
+		return seqs
+	}
+
+	tester.RequireEqual(t, 1, len(s.push(seqMessage("a", 1))))
+	tester.RequireEqual(t, 1, len(s.push(seqMessage("b", 10))))
+	tester.RequireEqual(t, 1, len(s.push(textMessage("unsequenced"))))
+
+	// reorder within the window
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 3))))
+	tester.RequireEqual(t, fmt.Sprint([]uint64{2, 3}), fmt.Sprint(delivered(s.push(seqMessage("a", 2)))))
+	tester.RequireEqual(t, 1, len(issues))
+	tester.RequireEqual(t, SequenceReordered, issues[0].Issue)
+
+	// duplicate
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 2))))
+	tester.RequireEqual(t, SequenceDuplicate, issues[1].Issue)
+
+	// gap when the window is full
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 6))))
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 7))))
+	tester.RequireEqual(t, fmt.Sprint([]uint64{6, 7}), fmt.Sprint(delivered(s.push(seqMessage("a", 9)))))
+	tester.RequireEqual(t, SequenceEvent{Issue: SequenceGap, Key: "a", Expected: 4, Got: 6}, issues[2])
+
+	// resume after reconnecting
+	tester.RequireEqual(t, fmt.Sprint([]uint64{9}), fmt.Sprint(delivered(s.reconnect())))
+	tester.RequireEqual(t, SequenceEvent{Issue: SequenceGap, Key: "a", Expected: 8, Got: 9}, issues[3])
+	tester.RequireEqual(t, 1, len(s.push(seqMessage("b", 11))))
+
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 20))))
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 21))))
+	tester.RequireEqual(t, 3, len(s.push(seqMessage("a", 22))))
+	tester.RequireEqual(t, SequenceEvent{Issue: SequenceGap, Key: "a", Expected: 10, Got: 20, Reconnected: true}, issues[4])
+
+	s.reset("a", 100)
+	tester.RequireEqual(t, 0, len(s.push(seqMessage("a", 99))))
+	tester.RequireEqual(t, 1, len(s.push(seqMessage("a", 100))))
+}
+
+func TestSequencerResetOnIssue(t *testing.T) {
+	var ws *WebSocket
+	ws = New(t.Context(), "", Option{
+		Sequencer: SequencerOption{
+			Sequence: JSONSequence(nil, JSONRouter("seq")),
+			Window:   2,
+			OnIssue: func(e SequenceEvent) {
+				if e.Issue == SequenceGap {
+					ws.ResetSequence(e.Key, e.Got+10)
+				}
+			},
+		},
+	})
+
+	done := make(chan []Message, 1)
+	go func() {
+		ws.sequencer.push(seqMessage("", 1))
+		ws.sequencer.push(seqMessage("", 3))
+		ws.sequencer.push(seqMessage("", 4))
+		done <- ws.sequencer.push(seqMessage("", 5))
+	}()
+
+	ready := waitMessage(t, done)
+	tester.RequireEqual(t, 3, len(ready))
+	tester.RequireEqual(t, 0, len(ws.sequencer.push(seqMessage("", 12))))
+	tester.RequireEqual(t, 1, len(ws.sequencer.push(seqMessage("", 13))))
+}
+
+func TestSequencerWindow(t *testing.T) {
+	s := newSequencer(SequencerOption{
+		Sequence: JSONSequence(nil, JSONRouter("seq")),
+		Window:   2,
+	})
+
+	s.push(seqMessage("", 1))
+	for _, seq := range []uint64{5, 7, 3, 9, 11} {
+		s.push(seqMessage("", seq))
+		tester.RequireTrue(t, len(s.streams[""].buffer) <= 2)
+	}
+}
+
+func TestSequencerReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	issues := make(chan SequenceEvent, 10)
+	client := New(ctx, url, Option{
+		Backoff: BackoffOption{Min: 1},
+		Sequencer: SequencerOption{
+			Sequence: JSONSequence(nil, JSONRouter("seq")),
+			OnIssue:  func(e SequenceEvent) { issues <- e },
+		},
+	})
+	events, unsubscribeEvents := client.Events()
+	defer unsubscribeEvents()
+
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	for waitMessage(t, events).Type != EventRegistered {
+	}
+
+	messages, unsubscribe := client.Subscribe()
+	defer unsubscribe()
+	waitPeers(t, hub, 1)
+
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, seqMessage("", 1).Data))
+	tester.RequireEqual(t, string(seqMessage("", 1).Data), string(waitMessage(t, messages).Data))
+
+	old := hub.Peers()[0]
+	old.Close()
+	for waitMessage(t, events).Type != EventRegistered {
+	}
+
+	// the hub may still hold the closed peer, or not yet hold the new one
+	waitPeers(t, hub, 1)
+	for _, exist := hub.Peer(old.ID()); exist; _, exist = hub.Peer(old.ID()) {
+		time.Sleep(time.Millisecond)
+		waitPeers(t, hub, 1)
+	}
+
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, seqMessage("", 5).Data))
+	tester.RequireEqual(t, string(seqMessage("", 5).Data), string(waitMessage(t, messages).Data))
+	tester.RequireEqual(t, SequenceEvent{Issue: SequenceGap, Expected: 2, Got: 5, Reconnected: true}, waitMessage(t, issues))
+}
@@ -0,0 +1,360 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/logs"
+	"github.com/yanun0323/pkg/channel"
+	"github.com/yanun0323/pkg/sys"
+)
+
+// HubOption defines websocket server settings for NewHub.
+type HubOption struct {
+	// Ping enables an automatic ping goroutine for every accepted connection.
+	Ping bool
+	// Heartbeat defines the interval, timeout and payload of the ping goroutine.
+	Heartbeat HeartbeatOption
+	// Trace defines the tracing of every accepted connection.
+	Trace TraceOption
+	// BindSignal closes the hub when the process receives SIGINT or SIGTERM.
+	//
+	// By default, the lifecycle is driven only by the context and Close.
+	BindSignal bool
+	// CheckOrigin returns true if the request Origin header is acceptable.
+	//
+	// Nil means using the same origin policy of gorilla/websocket.
+	CheckOrigin func(r *http.Request) bool
+	// ReadBufferSize specifies the I/O read buffer size in bytes.
+	ReadBufferSize int
+	// WriteBufferSize specifies the I/O write buffer size in bytes.
+	WriteBufferSize int
+}
+
+// PeerMessage is a message received from a specific Peer of Hub.
+type PeerMessage struct {
+	Peer    *Peer
+	Message Message
+}
+
+// Hub is the server side of websocket.
+//
+// It upgrades http requests into websocket connections, treats every connection as a Peer,
+// and publishes the messages from all peers to its subscribers.
+type Hub struct {
+	upgrader websocket.Upgrader
+	shutdown chan struct{}
+	ctx      context.Context
+
+	peers     map[uint64]*Peer
+	peersLock sync.RWMutex
+	nextPeer  atomic.Uint64
+
+	subscribers     map[uint64]*subscriber[PeerMessage]
+	subscribersLock sync.RWMutex
+	nextID          atomic.Uint64
+
+	end    atomic.Bool
+	option HubOption
+	logger logs.Logger
+}
+
+// Peer is a websocket connection accepted by Hub.
+type Peer struct {
+	id      uint64
+	d       *dialing
+	request *http.Request
+}
+
+// NewHub creates a new websocket server hub.
+//
+// Hub implements http.Handler, use it as the handler of the websocket endpoint.
+// The hub is closed when ctx is done.
+func NewHub(ctx context.Context, opts ...HubOption) *Hub {
+	option := HubOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+
+	h := &Hub{
+		upgrader: websocket.Upgrader{
+			CheckOrigin:     option.CheckOrigin,
+			ReadBufferSize:  option.ReadBufferSize,
+			WriteBufferSize: option.WriteBufferSize,
+		},
+		shutdown:    make(chan struct{}),
+		ctx:         ctx,
+		peers:       make(map[uint64]*Peer),
+		subscribers: make(map[uint64]*subscriber[PeerMessage]),
+		option:      option,
+		logger: logs.Get(ctx).With(
+			"hub", newLogID(),
+		),
+	}
+
+	context.AfterFunc(ctx, h.Close)
+	if option.BindSignal {
+		go bindSignal(sys.Shutdown(), h.shutdown, h.Close)
+	}
+
+	return h
+}
+
+// ServeHTTP upgrades the request into a websocket connection and tracks it as a Peer.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.IsClose() {
+		http.Error(w, ErrConnectionClose.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Errorf("upgrade connection from (%s), err: %+v", r.RemoteAddr, err)
+		return
+	}
+
+	if _, err := h.Accept(conn, r); err != nil {
+		h.logger.Errorf("accept connection from (%s), err: %+v", r.RemoteAddr, err)
+	}
+}
+
+// Accept tracks an upgraded websocket connection as a Peer.
+//
+// It is useful when the upgrading is done by the caller.
+func (h *Hub) Accept(conn *websocket.Conn, r *http.Request) (*Peer, error) {
+	if conn == nil {
+		return nil, errors.Wrap(ErrNilInstance, "accept connection")
+	}
+
+	h.peersLock.Lock()
+	if h.IsClose() {
+		h.peersLock.Unlock()
+		_ = conn.Close()
+		return nil, errors.Wrap(ErrConnectionClose, "hub closed")
+	}
+
+	id := h.nextPeer.Add(1)
+	logger := h.logger.With(
+		"peer", id,
+		"remote", conn.RemoteAddr().String(),
+	)
+
+	p := &Peer{
+		id: id,
+		d: newDialing(h.ctx, conn, logger, dialingOption{
+			ping:      h.option.Ping,
+			heartbeat: h.option.Heartbeat,
+			trace:     newTracer(h.option.Trace, logger),
+		}),
+		request: r,
+	}
+	h.peers[id] = p
+	h.peersLock.Unlock()
+
+	go func() {
+		defer h.removePeer(p)
+		defer p.Close()
+		for {
+			select {
+			case <-h.ctx.Done():
+				return
+			case <-h.shutdown:
+				return
+			case msg, ok := <-p.d.Message():
+				if !ok {
+					return
+				}
+
+				h.broadcast(PeerMessage{Peer: p, Message: msg})
+				msg.Release()
+			}
+		}
+	}()
+
+	logger.Info("peer connected")
+
+	return p, nil
+}
+
+func (h *Hub) removePeer(p *Peer) {
+	h.peersLock.Lock()
+	defer h.peersLock.Unlock()
+
+	delete(h.peers, p.id)
+}
+
+// broadcast delivers msg to a snapshot of the subscribers outside the lock.
+func (h *Hub) broadcast(msg PeerMessage) {
+	h.subscribersLock.RLock()
+	subscribers := make([]*subscriber[PeerMessage], 0, len(h.subscribers))
+	for _, sub := range h.subscribers {
+		subscribers = append(subscribers, sub)
+	}
+	h.subscribersLock.RUnlock()
+
+	var disconnected []uint64
+	for _, sub := range subscribers {
+		if !sub.push(PeerMessage{Peer: msg.Peer, Message: msg.Message.Retain()}) {
+			disconnected = append(disconnected, sub.id)
+		}
+	}
+
+	for _, id := range disconnected {
+		h.unsubscribe(id)
+	}
+}
+
+// Subscribe subscribes the messages received from all peers
+//
+// The optional SubscribeOption defines the queue capacity and overflow policy of the subscription.
+func (h *Hub) Subscribe(opts ...SubscribeOption) (<-chan PeerMessage, func()) {
+	option := SubscribeOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+
+	h.subscribersLock.Lock()
+	defer h.subscribersLock.Unlock()
+
+	id := h.nextID.Add(1)
+	sub := newSubscriber(id, normalizeSubscribeOption(option, nil), peerMessageOf)
+	sub.onDrop = func() {
+		h.logger.Warnf("broadcast message dropped for subscriber (%d), overflow policy: %s", id, sub.option.Overflow)
+	}
+	h.subscribers[id] = sub
+
+	return sub.ch, func() { h.unsubscribe(id) }
+}
+
+func (h *Hub) unsubscribe(id uint64) {
+	h.subscribersLock.Lock()
+	defer h.subscribersLock.Unlock()
+
+	if sub, ok := h.subscribers[id]; ok {
+		sub.close()
+		delete(h.subscribers, id)
+	}
+}
+
+func peerMessageOf(msg PeerMessage) Message {
+	return msg.Message
+}
+
+// Peer returns the connected peer with the provided id
+func (h *Hub) Peer(id uint64) (*Peer, bool) {
+	h.peersLock.RLock()
+	defer h.peersLock.RUnlock()
+
+	p, ok := h.peers[id]
+	return p, ok
+}
+
+// Peers returns all connected peers
+func (h *Hub) Peers() []*Peer {
+	h.peersLock.RLock()
+	defer h.peersLock.RUnlock()
+
+	peers := make([]*Peer, 0, len(h.peers))
+	for _, p := range h.peers {
+		peers = append(peers, p)
+	}
+
+	return peers
+}
+
+// BroadcastJSON writes a JSON message to all connected peers
+func (h *Hub) BroadcastJSON(v any) error {
+	var errs []error
+	for _, p := range h.Peers() {
+		if err := p.WriteJSON(v); err != nil {
+			errs = append(errs, errors.Wrapf(err, "peer (%d)", p.id))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// BroadcastRaw writes a raw message to all connected peers
+func (h *Hub) BroadcastRaw(messageType MessageType, data []byte) error {
+	var errs []error
+	for _, p := range h.Peers() {
+		if err := p.WriteRaw(messageType, data); err != nil {
+			errs = append(errs, errors.Wrapf(err, "peer (%d)", p.id))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes all peers and stops accepting new connections
+func (h *Hub) Close() {
+	if h.end.Swap(true) {
+		return
+	}
+
+	h.peersLock.Lock()
+	channel.SafeClose(h.shutdown)
+	for _, p := range h.peers {
+		p.Close()
+	}
+	h.peersLock.Unlock()
+
+	h.subscribersLock.Lock()
+	defer h.subscribersLock.Unlock()
+	for id, sub := range h.subscribers {
+		sub.close()
+		delete(h.subscribers, id)
+	}
+}
+
+// IsClose returns whether the hub is closed or not
+func (h *Hub) IsClose() bool {
+	return channel.IsClose(h.shutdown)
+}
+
+// Len returns the number of connected peers
+func (h *Hub) Len() int {
+	h.peersLock.RLock()
+	defer h.peersLock.RUnlock()
+
+	return len(h.peers)
+}
+
+// ID returns the identifier of the peer in its Hub
+func (p *Peer) ID() uint64 {
+	return p.id
+}
+
+// Request returns the http request which the peer upgraded from
+func (p *Peer) Request() *http.Request {
+	return p.request
+}
+
+// Done returns a channel which is closed when the peer connection is closed
+func (p *Peer) Done() <-chan struct{} {
+	return p.d.Done()
+}
+
+// IsClose returns whether the peer connection is closed or not
+func (p *Peer) IsClose() bool {
+	return p.d.IsClose()
+}
+
+// Close closes the peer connection
+func (p *Peer) Close() {
+	p.d.Close()
+}
+
+// WriteJSON writes a JSON message to the peer
+func (p *Peer) WriteJSON(v any) error {
+	return p.d.WriteJSON(v)
+}
+
+// WriteRaw writes a raw message to the peer
+func (p *Peer) WriteRaw(messageType MessageType, data []byte) error {
+	return p.d.WriteRaw(messageType, data)
+}
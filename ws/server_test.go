@@ -0,0 +1,120 @@
+package ws
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func newTestHub(t *testing.T, ctx context.Context, opts ...HubOption) (*Hub, string) {
+	t.Helper()
+
+	hub := NewHub(ctx, opts...)
+	server, url := serveHub(hub)
+	t.Cleanup(func() {
+		hub.Close()
+		server.Close()
+	})
+
+	return hub, url
+}
+
+// waitPeers waits until the hub holds n peers, e.g. before broadcasting to a client which just started.
+func waitPeers(t testing.TB, hub *Hub, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for hub.Len() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout, got %d peers, want %d", hub.Len(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func serveHub(hub *Hub) (*httptest.Server, string) {
+	server := httptest.NewServer(hub)
+	return server, "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func waitMessage[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+
+	select {
+	case msg, ok := <-ch:
+		tester.RequireTrue(t, ok)
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+
+	return *new(T)
+}
+
+func TestHub(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx, HubOption{Ping: true})
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	outbound, unsubscribeClient := client.Subscribe()
+	defer unsubscribeClient()
+
+	tester.RequireNoError(t, client.WriteRaw(MessageTypeText, []byte("hello")))
+	msg := waitMessage(t, inbound)
+	tester.RequireEqual(t, "hello", string(msg.Message.Data))
+	tester.RequireEqual(t, 1, hub.Len())
+
+	p, ok := hub.Peer(msg.Peer.ID())
+	tester.RequireTrue(t, ok)
+	tester.RequireNoError(t, p.WriteRaw(MessageTypeText, []byte("to peer")))
+	tester.RequireEqual(t, "to peer", string(waitMessage(t, outbound).Data))
+
+	tester.RequireNoError(t, hub.BroadcastJSON(map[string]string{"op": "all"}))
+	tester.RequireEqual(t, `{"op":"all"}`, strings.TrimSpace(string(waitMessage(t, outbound).Data)))
+
+	client.Close()
+	select {
+	case <-p.Done():
+	case <-time.After(3 * time.Second):
+		t.Fatal("peer is not closed")
+	}
+}
+
+func TestHubSubscribeOverflow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dropped := make(chan string, 10)
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe(SubscribeOption{
+		Cap:      1,
+		Overflow: OverflowDropOldest,
+		OnDrop: func(msg Message, _ Overflow) {
+			dropped <- string(msg.Data)
+		},
+	})
+	defer unsubscribe()
+
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	for _, data := range []string{"1", "2", "3"} {
+		tester.RequireNoError(t, client.WriteRaw(MessageTypeText, []byte(data)))
+	}
+
+	tester.RequireEqual(t, "1", waitMessage(t, dropped))
+	tester.RequireEqual(t, "2", waitMessage(t, dropped))
+	tester.RequireEqual(t, "3", string(waitMessage(t, inbound).Message.Data))
+}
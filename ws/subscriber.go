@@ -0,0 +1,365 @@
+package ws
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yanun0323/pkg/channel"
+)
+
+const (
+	_defaultBlockTimeout = time.Second
+)
+
+// Overflow defines how a subscription handles an incoming message when its queue is full.
+type Overflow int
+
+const (
+	// OverflowDropNewest drops the incoming message. It is the default policy.
+	OverflowDropNewest Overflow = iota
+	// OverflowDropOldest drops the oldest queued message to make room for the incoming one.
+	OverflowDropOldest
+	// OverflowBlock blocks the broadcasting until the queue has room or SubscribeOption.BlockTimeout elapses.
+	OverflowBlock
+	// OverflowDisconnect closes the subscription.
+	OverflowDisconnect
+	// OverflowCoalesce keeps only the latest queued message of every key extracted by SubscribeOption.CoalesceKey.
+	OverflowCoalesce
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case OverflowDropNewest:
+		return "DropNewest"
+	case OverflowDropOldest:
+		return "DropOldest"
+	case OverflowBlock:
+		return "Block"
+	case OverflowDisconnect:
+		return "Disconnect"
+	case OverflowCoalesce:
+		return "Coalesce"
+	default:
+		return fmt.Sprintf("Unknown(%d)", o)
+	}
+}
+
+// SubscribeOption defines the queue settings of a subscription.
+type SubscribeOption struct {
+	// Cap is the queue capacity of the subscription.
+	//
+	// Zero means using the default capacity 1,000.
+	Cap int
+	// Overflow is the policy applied when the queue is full.
+	Overflow Overflow
+	// BlockTimeout limits how long OverflowBlock waits for the queue.
+	//
+	// Zero means using the default timeout 1s.
+	BlockTimeout time.Duration
+	// CoalesceKey extracts the key for OverflowCoalesce.
+	//
+	// Nil means using Option.Router. Messages without key are never coalesced.
+	CoalesceKey Router
+	// OnDrop is invoked with every message dropped by the overflow policy.
+	OnDrop func(Message, Overflow)
+}
+
+func normalizeSubscribeOption(option SubscribeOption, router Router) SubscribeOption {
+	if option.Cap <= 0 {
+		option.Cap = _defaultMessageQueueCap
+	}
+	if option.BlockTimeout <= 0 {
+		option.BlockTimeout = _defaultBlockTimeout
+	}
+	if option.CoalesceKey == nil {
+		option.CoalesceKey = router
+	}
+
+	return option
+}
+
+// subscriber is the queue of a subscription with the overflow policy.
+//
+// It is shared by fanout and Hub, message extracts the Message of a value for coalescing, OnDrop and releasing.
+// push may run outside the lock of the subscriber set, so close never races a pending push.
+type subscriber[T any] struct {
+	id      uint64
+	ch      chan T
+	message func(T) Message
+	option  SubscribeOption
+	// onDrop is invoked at the drop site with every dropped message, used for counting and logging.
+	onDrop func()
+	// onClose is invoked once when the subscriber is closed.
+	onClose func()
+
+	// sendLock guards ch against sending after close, quit aborts a blocking push.
+	sendLock  sync.RWMutex
+	done      bool
+	quit      chan struct{}
+	closeOnce sync.Once
+
+	// coalescing queue, only used by OverflowCoalesce
+	mu          sync.Mutex
+	pending     map[string]*coalesced[T]
+	order       []string
+	forwarding  bool
+	inflight    *coalesced[T]
+	inflightVer uint64
+	nextKey     uint64
+	notify      chan struct{}
+}
+
+// coalesced is a pending message of the coalescing queue, ver increases whenever msg is replaced.
+type coalesced[T any] struct {
+	msg T
+	ver uint64
+}
+
+func messageOf(msg Message) Message {
+	return msg
+}
+
+func newSubscriber[T any](id uint64, option SubscribeOption, message func(T) Message) *subscriber[T] {
+	s := &subscriber[T]{
+		id:      id,
+		ch:      make(chan T, option.Cap),
+		message: message,
+		option:  option,
+		quit:    make(chan struct{}),
+	}
+
+	if option.Overflow == OverflowCoalesce {
+		s.pending = make(map[string]*coalesced[T])
+		s.notify = make(chan struct{}, 1)
+		go s.forward()
+	}
+
+	return s
+}
+
+// push delivers msg with the overflow policy, returns false if the subscriber should be disconnected.
+//
+// msg is released if the subscriber is already closed.
+func (s *subscriber[T]) push(msg T) bool {
+	if s.option.Overflow == OverflowCoalesce {
+		s.coalesce(msg)
+		return true
+	}
+
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+
+	if s.done {
+		s.message(msg).Release()
+		return true
+	}
+
+	switch s.option.Overflow {
+	case OverflowDropOldest:
+		if channel.TryPush(s.ch, msg) {
+			return true
+		}
+
+		if old, ok := channel.TryReceive(s.ch); ok {
+			s.drop(old)
+		}
+
+		if !channel.TryPush(s.ch, msg) {
+			s.drop(msg)
+		}
+	case OverflowBlock:
+		if channel.TryPush(s.ch, msg) {
+			return true
+		}
+
+		timer := time.NewTimer(s.option.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.ch <- msg:
+		case <-timer.C:
+			s.drop(msg)
+		case <-s.quit:
+			s.message(msg).Release()
+		}
+	case OverflowDisconnect:
+		if !channel.TryPush(s.ch, msg) {
+			s.drop(msg)
+			return false
+		}
+	default:
+		if !channel.TryPush(s.ch, msg) {
+			s.drop(msg)
+		}
+	}
+
+	return true
+}
+
+func (s *subscriber[T]) drop(v T) {
+	msg := s.message(v)
+	if s.onDrop != nil {
+		s.onDrop()
+	}
+	if s.option.OnDrop != nil {
+		s.option.OnDrop(msg, s.option.Overflow)
+	}
+	msg.Release()
+}
+
+func (s *subscriber[T]) coalesce(msg T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		s.message(msg).Release()
+		return
+	}
+
+	if !s.forwarding && len(s.order) == 0 && channel.TryPush(s.ch, msg) {
+		return
+	}
+
+	key, ok := "", false
+	if s.option.CoalesceKey != nil {
+		key, ok = s.option.CoalesceKey(s.message(msg))
+	}
+
+	if ok {
+		key = "k" + key
+		if e, exist := s.pending[key]; exist {
+			// the message being sent by forward is dropped by forward if the send loses
+			if e != s.inflight || e.ver != s.inflightVer {
+				s.drop(e.msg)
+			}
+			e.msg = msg
+			e.ver++
+			if e == s.inflight {
+				channel.TryPush(s.notify, struct{}{})
+			}
+			return
+		}
+	} else {
+		s.nextKey++
+		key = "u" + strconv.FormatUint(s.nextKey, 10)
+	}
+
+	if len(s.order) >= s.option.Cap {
+		s.drop(msg)
+		return
+	}
+
+	s.pending[key] = &coalesced[T]{msg: msg}
+	s.order = append(s.order, key)
+	channel.TryPush(s.notify, struct{}{})
+}
+
+// forward moves the coalescing queue into the channel.
+//
+// The head of the queue stays pending while it is being sent, so a newer message of the same key
+// still replaces it when the consumer is slow.
+func (s *subscriber[T]) forward() {
+	defer s.flush()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-s.notify:
+		}
+
+		for {
+			s.mu.Lock()
+			if len(s.order) == 0 {
+				s.forwarding = false
+				s.mu.Unlock()
+				break
+			}
+
+			e := s.pending[s.order[0]]
+			msg, ver := e.msg, e.ver
+			s.inflight, s.inflightVer = e, ver
+			s.forwarding = true
+			s.mu.Unlock()
+
+			select {
+			case <-s.quit:
+				s.mu.Lock()
+				if e.ver != ver {
+					s.message(msg).Release()
+				}
+				s.inflight = nil
+				s.mu.Unlock()
+				return
+			case s.ch <- msg:
+				s.mu.Lock()
+				if e.ver == ver {
+					delete(s.pending, s.order[0])
+					s.order = s.order[1:]
+				}
+				s.inflight = nil
+				s.mu.Unlock()
+			case <-s.notify:
+				s.mu.Lock()
+				if e.ver != ver {
+					s.drop(msg)
+				}
+				s.inflight = nil
+				s.mu.Unlock()
+			}
+		}
+	}
+}
+
+// flush moves the pending messages of the coalescing queue into the channel as far as it has room,
+// releases the rest and closes the channel.
+func (s *subscriber[T]) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = true
+	for _, key := range s.order {
+		if msg := s.pending[key].msg; !channel.TryPush(s.ch, msg) {
+			s.message(msg).Release()
+		}
+		delete(s.pending, key)
+	}
+	s.order = nil
+	close(s.ch)
+}
+
+// queued returns the number of queued messages.
+func (s *subscriber[T]) queued() int {
+	if s.pending == nil {
+		return len(s.ch)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.ch) + len(s.order)
+}
+
+// close closes the subscriber, the messages already in the channel remain readable.
+//
+// The pending messages of OverflowCoalesce are moved into the channel as far as it has room,
+// the rest are released.
+func (s *subscriber[T]) close() {
+	s.closeOnce.Do(func() {
+		if s.onClose != nil {
+			defer s.onClose()
+		}
+
+		close(s.quit)
+		if s.option.Overflow == OverflowCoalesce {
+			return
+		}
+
+		s.sendLock.Lock()
+		defer s.sendLock.Unlock()
+
+		s.done = true
+		close(s.ch)
+	})
+}
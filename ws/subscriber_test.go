@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func textMessage(data string) Message {
+	return Message{Type: MessageTypeText, Data: []byte(data)}
+}
+
+func TestSubscribeOverflow(t *testing.T) {
+	ws := New(t.Context(), "", Option{Router: JSONRouter("k")})
+
+	var dropped []string
+	newest, unsubscribeNewest := ws.Subscribe(SubscribeOption{Cap: 1})
+	defer unsubscribeNewest()
+	oldest, unsubscribeOldest := ws.Subscribe(SubscribeOption{
+		Cap:      1,
+		Overflow: OverflowDropOldest,
+		OnDrop: func(msg Message, _ Overflow) {
+			dropped = append(dropped, string(msg.Data))
+		},
+	})
+	defer unsubscribeOldest()
+	disconnect, unsubscribeDisconnect := ws.Subscribe(SubscribeOption{Cap: 1, Overflow: OverflowDisconnect})
+	defer unsubscribeDisconnect()
+
+	ws.broadcast(textMessage(`{"k":"1"}`))
+	ws.broadcast(textMessage(`{"k":"2"}`))
+
+	tester.RequireEqual(t, `{"k":"1"}`, string(waitMessage(t, newest).Data))
+	tester.RequireEqual(t, `{"k":"2"}`, string(waitMessage(t, oldest).Data))
+	tester.RequireEqual(t, 1, len(dropped))
+	tester.RequireEqual(t, `{"k":"1"}`, dropped[0])
+
+	tester.RequireEqual(t, `{"k":"1"}`, string(waitMessage(t, disconnect).Data))
+	_, ok := <-disconnect
+	tester.RequireFalse(t, ok)
+
+	tester.RequireEqual(t, 2, ws.Len())
+	tester.RequireEqual(t, uint64(3), ws.Dropped())
+}
+
+func TestSubscribeCoalesce(t *testing.T) {
+	ws := New(t.Context(), "", Option{Router: JSONRouter("k")})
+
+	ch, unsubscribe := ws.Subscribe(SubscribeOption{Cap: 1, Overflow: OverflowCoalesce})
+	defer unsubscribe()
+
+	ws.broadcast(textMessage(`{"k":"a","v":1}`))
+	ws.broadcast(textMessage(`{"k":"b","v":1}`))
+	ws.broadcast(textMessage(`{"k":"b","v":2}`))
+
+	tester.RequireEqual(t, `{"k":"a","v":1}`, string(waitMessage(t, ch).Data))
+	tester.RequireEqual(t, `{"k":"b","v":2}`, string(waitMessage(t, ch).Data))
+	tester.RequireEqual(t, uint64(1), ws.Dropped())
+}
+
+func TestSubscribeBlockOutsideLock(t *testing.T) {
+	ws := New(t.Context(), "")
+
+	blocked, unsubscribeBlocked := ws.Subscribe(SubscribeOption{Cap: 1, Overflow: OverflowBlock, BlockTimeout: time.Minute})
+	ws.broadcast(textMessage("1"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ws.broadcast(textMessage("2"))
+	}()
+
+	_, unsubscribe := ws.Subscribe()
+	unsubscribe()
+	tester.RequireEqual(t, 1, ws.Len())
+
+	unsubscribeBlocked()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("broadcast still blocked after unsubscribing")
+	}
+
+	tester.RequireEqual(t, "1", string(waitMessage(t, blocked).Data))
+	_, ok := <-blocked
+	tester.RequireFalse(t, ok)
+}
+
+func TestSubscribeCoalesceClose(t *testing.T) {
+	ws := New(t.Context(), "", Option{Router: JSONRouter("k")})
+
+	ch, unsubscribe := ws.Subscribe(SubscribeOption{Cap: 2, Overflow: OverflowCoalesce})
+	ws.broadcast(textMessage(`{"k":"a"}`))
+	ws.broadcast(textMessage(`{"k":"b"}`))
+	ws.broadcast(textMessage(`{"k":"c"}`))
+	unsubscribe()
+
+	var got []string
+	for msg := range ch {
+		got = append(got, string(msg.Data))
+	}
+	tester.RequireTrue(t, len(got) >= 2)
+	tester.RequireEqual(t, `{"k":"a"}`, got[0])
+}
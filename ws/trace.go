@@ -0,0 +1,262 @@
+package ws
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/logs"
+)
+
+// Tracer observes the frames and lifecycle of a connection.
+//
+// The methods are invoked synchronously by the connection goroutines, and must not block.
+// Message.Data is only valid during the call, copy it to keep it.
+type Tracer interface {
+	// OnDial is invoked after every dial attempt, err is nil if it succeeds.
+	OnDial(url string, err error)
+	// OnRead is invoked with every frame received from the peer.
+	OnRead(msg Message)
+	// OnWrite is invoked with every message written to the peer, err is nil if it succeeds.
+	OnWrite(msg Message, err error)
+	// OnClose is invoked when the connection is closed with the cause.
+	OnClose(err error)
+}
+
+// TraceOption defines the tracing of every connection.
+type TraceOption struct {
+	// Tracer observes the frames and lifecycle, e.g. NewJSONTracer.
+	//
+	// Nil disables tracing.
+	Tracer Tracer
+	// Redact returns the message passed to Tracer, e.g. masking api keys and signatures.
+	//
+	// It must not modify the data of msg in place, return a copy instead.
+	Redact func(msg Message) Message
+	// RedactURL returns the url passed to Tracer.OnDial, e.g. masking the tokens in the query.
+	//
+	// Nil means stripping the user info, query and fragment of the url.
+	RedactURL func(url string) string
+	// Truncate is the maximum length of Message.Data passed to Tracer.
+	//
+	// Zero means no truncation.
+	Truncate int
+}
+
+// tracer applies the redaction and truncation before passing messages to Tracer.
+type tracer struct {
+	option TraceOption
+}
+
+func newTracer(option TraceOption, logger logs.Logger) *tracer {
+	if option.Tracer == nil {
+		if !Debug {
+			return nil
+		}
+
+		option.Tracer = logTracer{logger: logger}
+		if option.Truncate <= 0 {
+			option.Truncate = _debugMessageLimit
+		}
+	}
+
+	return &tracer{option: option}
+}
+
+func (t *tracer) prepare(msg Message) Message {
+	msg.buf = nil
+	if t.option.Redact != nil {
+		msg = t.option.Redact(msg)
+	}
+
+	if t.option.Truncate > 0 && len(msg.Data) > t.option.Truncate {
+		msg.Data = msg.Data[:t.option.Truncate]
+	}
+
+	return msg
+}
+
+func (t *tracer) dial(url string, err error) {
+	if t == nil {
+		return
+	}
+
+	if t.option.RedactURL != nil {
+		url = t.option.RedactURL(url)
+	} else {
+		url = stripURL(url)
+	}
+
+	t.option.Tracer.OnDial(url, err)
+}
+
+// stripURL removes the user info, query and fragment which may carry credentials.
+func stripURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "<invalid url>"
+	}
+
+	u.User = nil
+	u.RawQuery = ""
+	u.ForceQuery = false
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+func (t *tracer) read(msg Message) {
+	if t != nil {
+		t.option.Tracer.OnRead(t.prepare(msg))
+	}
+}
+
+func (t *tracer) write(msg Message, err error) {
+	if t != nil {
+		t.option.Tracer.OnWrite(t.prepare(msg), err)
+	}
+}
+
+func (t *tracer) close(err error) {
+	if t != nil {
+		t.option.Tracer.OnClose(err)
+	}
+}
+
+// logTracer logs the frames at info level, it is used when Debug is set.
+type logTracer struct {
+	logger logs.Logger
+}
+
+func (l logTracer) OnDial(url string, err error) {
+	if err != nil {
+		l.logger.Infof("dial (%s), err: %+v", url, err)
+		return
+	}
+
+	l.logger.Infof("dial (%s) succeed", url)
+}
+
+func (l logTracer) OnRead(msg Message) {
+	l.logger.Infof("%s %s message: %s", _symbolRecv, msg.Type, string(msg.Data))
+}
+
+func (l logTracer) OnWrite(msg Message, err error) {
+	if err != nil {
+		l.logger.Infof("%s write %s message: %s, err: %+v", _symbolWrite, msg.Type, string(msg.Data), err)
+		return
+	}
+
+	l.logger.Infof("%s write %s message: %s", _symbolWrite, msg.Type, string(msg.Data))
+}
+
+func (l logTracer) OnClose(err error) {
+	l.logger.Infof("closed, cause: %v", err)
+}
+
+type traceJSON struct {
+	Time   time.Time   `json:"time"`
+	Event  string      `json:"event"`
+	URL    string      `json:"url,omitempty"`
+	Type   MessageType `json:"type,omitempty"`
+	Text   string      `json:"text,omitempty"`
+	Binary []byte      `json:"binary,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// JSONTracer is a Tracer writing every event as a JSON object in a line, e.g. for post-mortems.
+//
+// Events are written unbuffered so they survive a crash. Share it across connections to trace into the same file.
+type JSONTracer struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	err    error
+}
+
+// NewJSONTracer creates a JSONTracer writing into w.
+func NewJSONTracer(w io.Writer) *JSONTracer {
+	t := &JSONTracer{enc: json.NewEncoder(w)}
+	if c, ok := w.(io.Closer); ok {
+		t.closer = c
+	}
+
+	return t
+}
+
+// CreateJSONTracer creates a JSONTracer writing into the file of path.
+func CreateJSONTracer(path string) (*JSONTracer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open trace file (%s)", path)
+	}
+
+	return NewJSONTracer(f), nil
+}
+
+func (t *JSONTracer) OnDial(url string, err error) {
+	t.write(traceJSON{Event: "dial", URL: url}, err)
+}
+
+func (t *JSONTracer) OnRead(msg Message) {
+	t.write(traceMessage("read", msg), nil)
+}
+
+func (t *JSONTracer) OnWrite(msg Message, err error) {
+	t.write(traceMessage("write", msg), err)
+}
+
+func (t *JSONTracer) OnClose(err error) {
+	t.write(traceJSON{Event: "close"}, err)
+}
+
+// Err returns the first error of writing events, the later events are discarded after it.
+func (t *JSONTracer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.err
+}
+
+// Close closes the underlying writer if it is an io.Closer.
+func (t *JSONTracer) Close() error {
+	if t.closer != nil {
+		return errors.Wrap(t.closer.Close(), "close tracer")
+	}
+
+	return nil
+}
+
+func (t *JSONTracer) write(v traceJSON, err error) {
+	v.Time = time.Now()
+	if err != nil {
+		v.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return
+	}
+
+	if err := t.enc.Encode(v); err != nil {
+		t.err = errors.Wrap(err, "write trace")
+	}
+}
+
+func traceMessage(event string, msg Message) traceJSON {
+	v := traceJSON{Event: event, Type: msg.Type}
+	if msg.Type == MessageTypeBinary {
+		v.Binary = msg.Data
+	} else {
+		v.Text = string(msg.Data)
+	}
+
+	return v
+}
@@ -0,0 +1,217 @@
+package ws
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/channel"
+)
+
+const (
+	_defaultDecodeErrorQueueCap = 100
+)
+
+// TypedOption defines settings for SubscribeTyped.
+type TypedOption struct {
+	// Codec decodes the messages.
+	//
+	// Nil means using JSONCodec.
+	Codec Codec
+	// Cap is the queue capacity of the subscription, values are dropped when the queue is full.
+	//
+	// Zero means using the default capacity 1,000.
+	Cap int
+}
+
+// Subscribable is the message source of SubscribeTyped, implemented by WebSocket and Pool.
+type Subscribable interface {
+	subscriptions() *fanout
+}
+
+func (ws *WebSocket) subscriptions() *fanout {
+	return ws.fanout
+}
+
+func (p *Pool) subscriptions() *fanout {
+	return p.fanout
+}
+
+// SubscribeTyped subscribes the messages decoded into T.
+//
+// Every message is decoded once for all typed subscriptions with the same T and codec,
+// then delivered to the subscriptions whose filter accepts the value. Nil filter accepts all values.
+//
+// Decode failures are pushed into the error channel, and dropped when it is full.
+// Both channels are closed by the returned unsubscribe function or closing the source.
+func SubscribeTyped[T any](src Subscribable, filter func(T) bool, opts ...TypedOption) (<-chan T, <-chan error, func()) {
+	option := TypedOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
+	if option.Codec == nil {
+		option.Codec = JSONCodec{}
+	}
+	if option.Cap <= 0 {
+		option.Cap = _defaultMessageQueueCap
+	}
+
+	f := src.subscriptions()
+	key := typedKey{codec: option.Codec.Name(), typ: reflect.TypeFor[T]()}
+	sub := &typedSubscriber[T]{
+		filter: filter,
+		ch:     make(chan T, option.Cap),
+		errs:   make(chan error, _defaultDecodeErrorQueueCap),
+	}
+
+	f.subscribersLock.Lock()
+	defer f.subscribersLock.Unlock()
+
+	id := subscriptionIDs.Add(1)
+	sub.onDrop, sub.onClose = f.track(fmt.Sprintf("typed:%s#%d", key.typ, id), OverflowDropNewest)
+	group, ok := f.typed[key].(*typedGroup[T])
+	if !ok {
+		group = &typedGroup[T]{codec: option.Codec, subscribers: make(map[uint64]*typedSubscriber[T])}
+		f.typed[key] = group
+	}
+	group.subscribers[id] = sub
+
+	unsubscribe := func() {
+		f.subscribersLock.Lock()
+		defer f.subscribersLock.Unlock()
+
+		if group.remove(id) == 0 && f.typed[key] == group {
+			delete(f.typed, key)
+		}
+	}
+
+	return sub.ch, sub.errs, unsubscribe
+}
+
+// DecodeErrors returns the number of messages failed to be decoded by typed subscriptions
+func (ws *WebSocket) DecodeErrors() uint64 {
+	return ws.fanout.decodeErrors.Load()
+}
+
+type typedKey struct {
+	codec string
+	typ   reflect.Type
+}
+
+// typedSubscribers is a group of typed subscriptions sharing the decoded values.
+type typedSubscribers interface {
+	// snapshot returns the function decoding a message once and pushing it to the current subscribers.
+	//
+	// It is called under the lock of the fanout, and the returned function runs outside it.
+	snapshot() func(f *fanout, msg Message)
+	remove(id uint64) int
+	close()
+	len() int
+	pending() int
+}
+
+type typedGroup[T any] struct {
+	codec       Codec
+	subscribers map[uint64]*typedSubscriber[T]
+}
+
+func (g *typedGroup[T]) snapshot() func(f *fanout, msg Message) {
+	codec := g.codec
+	subscribers := slices.Collect(maps.Values(g.subscribers))
+
+	return func(f *fanout, msg Message) {
+		var v T
+		if err := codec.Unmarshal(msg.Data, &v); err != nil {
+			err = errors.Wrapf(err, "decode %T with codec (%s)", v, codec.Name())
+			f.decodeErrors.Add(1)
+			f.logger.Debugf("typed subscribers, err: %+v", err)
+			for _, sub := range subscribers {
+				sub.pushError(err)
+			}
+			return
+		}
+
+		for _, sub := range subscribers {
+			if sub.filter != nil && !sub.filter(v) {
+				continue
+			}
+			sub.push(v)
+		}
+	}
+}
+
+func (g *typedGroup[T]) remove(id uint64) int {
+	if sub, ok := g.subscribers[id]; ok {
+		sub.close()
+		delete(g.subscribers, id)
+	}
+
+	return len(g.subscribers)
+}
+
+func (g *typedGroup[T]) close() {
+	for id, sub := range g.subscribers {
+		sub.close()
+		delete(g.subscribers, id)
+	}
+}
+
+func (g *typedGroup[T]) len() int {
+	return len(g.subscribers)
+}
+
+func (g *typedGroup[T]) pending() int {
+	count := 0
+	for _, sub := range g.subscribers {
+		count += len(sub.ch)
+	}
+
+	return count
+}
+
+type typedSubscriber[T any] struct {
+	filter  func(T) bool
+	ch      chan T
+	errs    chan error
+	onDrop  func()
+	onClose func()
+
+	// sendLock guards the channels against sending after close, the pushes run outside the lock of the fanout.
+	sendLock  sync.RWMutex
+	done      bool
+	closeOnce sync.Once
+}
+
+func (s *typedSubscriber[T]) push(v T) {
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+
+	if !s.done && !channel.TryPush(s.ch, v) {
+		s.onDrop()
+	}
+}
+
+func (s *typedSubscriber[T]) pushError(err error) {
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+
+	if !s.done {
+		channel.TryPush(s.errs, err)
+	}
+}
+
+func (s *typedSubscriber[T]) close() {
+	s.closeOnce.Do(func() {
+		s.onClose()
+
+		s.sendLock.Lock()
+		defer s.sendLock.Unlock()
+
+		s.done = true
+		close(s.ch)
+		close(s.errs)
+	})
+}
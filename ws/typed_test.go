@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+type countingCodec struct {
+	decoded atomic.Int64
+}
+
+func (*countingCodec) Name() string {
+	return "counting"
+}
+
+func (c *countingCodec) Unmarshal(data []byte, v any) error {
+	c.decoded.Add(1)
+	return json.Unmarshal(data, v)
+}
+
+func TestSubscribeTyped(t *testing.T) {
+	type trade struct {
+		Symbol string  `json:"symbol"`
+		Price  float64 `json:"price"`
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	client := New(ctx, url)
+	tester.RequireNoError(t, client.Start(ctx))
+	defer client.Close()
+
+	codec := &countingCodec{}
+	all, errs, unsubscribeAll := SubscribeTyped[trade](client, nil, TypedOption{Codec: codec})
+	defer unsubscribeAll()
+
+	btc, _, unsubscribeBTC := SubscribeTyped(client, func(v trade) bool { return v.Symbol == "BTC" }, TypedOption{Codec: codec})
+	defer unsubscribeBTC()
+	tester.RequireEqual(t, 2, client.Len())
+	waitPeers(t, hub, 1)
+
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, []byte(`{"symbol":"ETH","price":1}`)))
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, []byte(`{"symbol":"BTC","price":2}`)))
+	tester.RequireNoError(t, hub.BroadcastRaw(MessageTypeText, []byte(`not json`)))
+
+	tester.RequireEqual(t, trade{Symbol: "ETH", Price: 1}, waitMessage(t, all))
+	tester.RequireEqual(t, trade{Symbol: "BTC", Price: 2}, waitMessage(t, all))
+	tester.RequireEqual(t, trade{Symbol: "BTC", Price: 2}, waitMessage(t, btc))
+	tester.RequireError(t, waitMessage(t, errs))
+
+	tester.RequireEqual(t, int64(3), codec.decoded.Load())
+	tester.RequireEqual(t, uint64(1), client.DecodeErrors())
+
+	unsubscribeBTC()
+	_, ok := <-btc
+	tester.RequireFalse(t, ok)
+	tester.RequireEqual(t, 1, client.Len())
+}
+
+func TestSubscribeTypedFilterSubscribes(t *testing.T) {
+	ws := New(t.Context(), "")
+
+	filter := func(v map[string]any) bool {
+		_, unsubscribe := ws.Subscribe()
+		unsubscribe()
+		return true
+	}
+	values, _, unsubscribe := SubscribeTyped(ws, filter)
+	defer unsubscribe()
+
+	done := make(chan struct{}, 1)
+	go func() {
+		ws.broadcast(textMessage(`{"k":"a"}`))
+		done <- struct{}{}
+	}()
+
+	waitMessage(t, done)
+	tester.RequireEqual(t, "a", waitMessage(t, values)["k"])
+}
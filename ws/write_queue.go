@@ -0,0 +1,322 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/yanun0323/errors"
+	"github.com/yanun0323/pkg/channel"
+)
+
+var (
+	// ErrWriteQueueFull represents the error of writing into a full outbound queue.
+	ErrWriteQueueFull = errors.New("write queue full")
+
+	// ErrWriteExpired represents the error of a queued message exceeding its TTL before being written.
+	ErrWriteExpired = errors.New("write expired")
+)
+
+// WriteQueueOption defines the outbound queue which buffers writes while the connection is unavailable.
+type WriteQueueOption struct {
+	// Enable enables the outbound queue and the dedicated writer goroutine.
+	Enable bool
+	// Cap is the capacity of the outbound queue.
+	//
+	// Zero means using the default capacity 1,000.
+	Cap int
+	// Overflow is the policy applied when the queue is full.
+	//
+	// Only OverflowDropNewest, OverflowDropOldest and OverflowBlock are supported,
+	// others are treated as OverflowDropNewest.
+	Overflow Overflow
+	// BlockTimeout limits how long OverflowBlock waits for the queue.
+	//
+	// Zero means using the default timeout 1s.
+	BlockTimeout time.Duration
+	// TTL is the maximum duration a message stays in the queue before being dropped.
+	//
+	// Zero means never expired.
+	TTL time.Duration
+}
+
+func normalizeWriteQueue(option WriteQueueOption) WriteQueueOption {
+	if option.Cap <= 0 {
+		option.Cap = _defaultMessageQueueCap
+	}
+	if option.BlockTimeout <= 0 {
+		option.BlockTimeout = _defaultBlockTimeout
+	}
+
+	return option
+}
+
+type outbound struct {
+	msg      Message
+	deadline time.Time
+	done     chan error
+	queued   *pendingCount
+	// onWrite is invoked with the connection right before msg is written on it.
+	onWrite func(*dialing)
+}
+
+// writeTo writes the message of o on d.
+func (o *outbound) writeTo(d *dialing) error {
+	if o.onWrite != nil {
+		o.onWrite(d)
+	}
+
+	return d.WriteRaw(o.msg.Type, o.msg.Data)
+}
+
+func (o *outbound) finish(err error) {
+	if o.queued != nil {
+		o.queued.add(-1)
+	}
+	if o.done != nil {
+		o.done <- err
+	}
+}
+
+// WriteMessage writes a message to the websocket connection and waits until it is written to the socket.
+//
+// With Option.WriteQueue enabled, the message is written by the writer goroutine and buffered while the connection is unavailable.
+func (ws *WebSocket) WriteMessage(ctx context.Context, msg Message) error {
+	if !ws.option.WriteQueue.Enable {
+		d := ws.getConn()
+		if d == nil {
+			return errors.Wrap(ErrConnectionClose, "nil ws connection")
+		}
+
+		if err := ws.limit(ctx, MessageClassWrite); err != nil {
+			return errors.Wrap(err, "write message")
+		}
+
+		return d.WriteRaw(msg.Type, msg.Data)
+	}
+
+	msg.Data = bytes.Clone(msg.Data)
+	return ws.send(ctx, &outbound{msg: msg, done: make(chan error, 1)})
+}
+
+// send hands item to the writer goroutine and waits until it is written.
+func (ws *WebSocket) send(ctx context.Context, item *outbound) error {
+	if ws.State() == StateConnected {
+		return ws.writeDirect(ctx, item)
+	}
+
+	if err := ws.enqueue(item); err != nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for writing")
+	case err := <-item.done:
+		return err
+	}
+}
+
+func (ws *WebSocket) enqueueJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "marshal json (%v)", v)
+	}
+
+	return ws.write(&outbound{msg: Message{Type: MessageTypeText, Data: data}})
+}
+
+// enqueueRaw copies data, the caller is free to reuse it after returning.
+func (ws *WebSocket) enqueueRaw(messageType MessageType, data []byte) error {
+	return ws.write(&outbound{msg: Message{Type: messageType, Data: bytes.Clone(data)}})
+}
+
+// write hands item to the writer goroutine without waiting for the delivery.
+//
+// While registers are replaying, item bypasses the queue and is written ahead of the queued messages.
+func (ws *WebSocket) write(item *outbound) error {
+	if ws.State() == StateConnected {
+		return ws.writeDirect(context.Background(), item)
+	}
+
+	return ws.enqueue(item)
+}
+
+// writeDirect hands item to the writer goroutine ahead of the queue, and waits until it is written.
+func (ws *WebSocket) writeDirect(ctx context.Context, item *outbound) error {
+	if err := ws.limit(ctx, MessageClassWrite); err != nil {
+		return errors.Wrap(err, "write message")
+	}
+
+	if item.done == nil {
+		item.done = make(chan error, 1)
+	}
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "wait for writer")
+	case <-ws.shutdown:
+		return errors.Wrap(ErrConnectionClose, "websocket closed")
+	case <-ws.writer:
+		return errors.Wrap(ErrConnectionClose, "writer stopped")
+	case ws.direct <- item:
+	}
+
+	return <-item.done
+}
+
+func (ws *WebSocket) enqueue(item *outbound) error {
+	if ws.IsClose() {
+		return errors.Wrap(ErrConnectionClose, "websocket closed")
+	}
+
+	option := ws.option.WriteQueue
+	if option.TTL > 0 {
+		item.deadline = time.Now().Add(option.TTL)
+	}
+
+	ws.queued.add(1)
+	item.queued = &ws.queued
+	if err := ws.push(item, option); err != nil {
+		item.queued = nil
+		ws.queued.add(-1)
+		return err
+	}
+
+	return nil
+}
+
+func (ws *WebSocket) push(item *outbound, option WriteQueueOption) error {
+	if channel.TryPush(ws.outbound, item) {
+		return nil
+	}
+
+	switch option.Overflow {
+	case OverflowDropOldest:
+		if old, ok := channel.TryReceive(ws.outbound); ok {
+			old.finish(errors.Wrap(ErrWriteQueueFull, "dropped by newer message"))
+		}
+
+		if channel.TryPush(ws.outbound, item) {
+			return nil
+		}
+	case OverflowBlock:
+		timer := time.NewTimer(option.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case ws.outbound <- item:
+			return nil
+		case <-ws.shutdown:
+			return errors.Wrap(ErrConnectionClose, "websocket closed")
+		case <-timer.C:
+		}
+	}
+
+	return errors.Wrap(ErrWriteQueueFull, "enqueue message")
+}
+
+// writeLoop is the dedicated writer goroutine of the outbound queue.
+//
+// It holds the queued messages until registers succeed, and keeps the failed one for the next connection.
+func (ws *WebSocket) writeLoop(ctx context.Context) {
+	defer close(ws.writer)
+	defer ws.failOutbound(ErrConnectionClose)
+
+	var (
+		item   *outbound
+		broken *dialing
+	)
+	for {
+		if item == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ws.shutdown:
+				return
+			case direct := <-ws.direct:
+				ws.writeConn(direct)
+				continue
+			case item = <-ws.outbound:
+			}
+		}
+
+		if !item.deadline.IsZero() && time.Now().After(item.deadline) {
+			item.finish(errors.Wrap(ErrWriteExpired, "exceed ttl"))
+			item = nil
+			continue
+		}
+
+		d := ws.getConn()
+		if d == nil || d == broken || ws.State() != StateReady {
+			var (
+				timer  *time.Timer
+				expire <-chan time.Time
+			)
+			if !item.deadline.IsZero() {
+				timer = time.NewTimer(time.Until(item.deadline))
+				expire = timer.C
+			}
+
+			select {
+			case <-ctx.Done():
+				item.finish(errors.Wrap(ctx.Err(), "context done"))
+				return
+			case <-ws.shutdown:
+				item.finish(errors.Wrap(ErrConnectionClose, "websocket closed"))
+				return
+			case <-ws.writable:
+			case direct := <-ws.direct:
+				ws.writeConn(direct)
+			case <-expire:
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+
+		if err := ws.limit(ctx, MessageClassWrite); err != nil {
+			item.finish(errors.Wrap(err, "write queued message"))
+			item = nil
+			if ws.IsClose() || ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		if err := item.writeTo(d); err != nil {
+			ws.logger.Warnf("write queued message failed, retry after reconnection, err: %+v", err)
+			broken = d
+			d.Close()
+			continue
+		}
+
+		item.finish(nil)
+		item = nil
+	}
+}
+
+// writeConn writes item to the current connection regardless of the state.
+func (ws *WebSocket) writeConn(item *outbound) {
+	d := ws.getConn()
+	if d == nil {
+		item.finish(errors.Wrap(ErrConnectionClose, "nil ws connection"))
+		return
+	}
+
+	item.finish(item.writeTo(d))
+}
+
+func (ws *WebSocket) failOutbound(err error) {
+	for {
+		item, ok := channel.TryReceive(ws.outbound)
+		if !ok {
+			return
+		}
+
+		item.finish(errors.Wrap(err, "drop queued message"))
+	}
+}
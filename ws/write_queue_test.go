@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/yanun0323/pkg/tester"
+)
+
+func TestWriteQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	client := New(ctx, url, Option{WriteQueue: WriteQueueOption{Enable: true}})
+	defer client.Close()
+
+	tester.RequireNoError(t, client.WriteRaw(MessageTypeText, []byte("before start")))
+	tester.RequireNoError(t, client.Start(ctx))
+	tester.RequireEqual(t, "before start", string(waitMessage(t, inbound).Message.Data))
+
+	events, unsubscribeEvents := client.Events()
+	defer unsubscribeEvents()
+
+	for _, p := range hub.Peers() {
+		p.Close()
+	}
+
+	for e := range events {
+		if e.Type == EventDisconnected {
+			break
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.WriteMessage(ctx, Message{Type: MessageTypeText, Data: []byte("during reconnection")})
+	}()
+
+	tester.RequireEqual(t, "during reconnection", string(waitMessage(t, inbound).Message.Data))
+	tester.RequireNoError(t, waitMessage(t, done))
+}
+
+func TestWriteQueueRegister(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub, url := newTestHub(t, ctx)
+	inbound, unsubscribe := hub.Subscribe()
+	defer unsubscribe()
+
+	received := make(chan string, 10)
+	go func() {
+		for msg := range inbound {
+			received <- string(msg.Message.Data)
+			if string(msg.Message.Data) == "register" {
+				_ = msg.Peer.WriteRaw(MessageTypeText, []byte("ack"))
+			}
+		}
+	}()
+
+	client := New(ctx, url, Option{WriteQueue: WriteQueueOption{Enable: true}})
+	defer client.Close()
+
+	data := []byte("queued")
+	tester.RequireNoError(t, client.WriteRaw(MessageTypeText, data))
+	copy(data, "reused")
+
+	tester.RequireNoError(t, client.Start(ctx, Sidecar{
+		Sender: func(_ context.Context, ws *WebSocket) error {
+			return ws.WriteRaw(MessageTypeText, []byte("register"))
+		},
+		Waiter: func(_ context.Context, msg Message) (bool, error) {
+			return string(msg.Data) == "ack", nil
+		},
+	}))
+
+	tester.RequireEqual(t, "register", waitMessage(t, received))
+	tester.RequireEqual(t, "queued", waitMessage(t, received))
+}
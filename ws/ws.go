@@ -2,30 +2,80 @@ package ws
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/yanun0323/errors"
 	"github.com/yanun0323/logs"
+	"github.com/yanun0323/pkg/atomics"
 	"github.com/yanun0323/pkg/channel"
 	"github.com/yanun0323/pkg/sys"
 )
 
 const (
-	_defaultMessageQueueCap int = 1_000
-	_defaultBackoffMin          = 100 * time.Millisecond
-	_defaultBackoffMax          = 30 * time.Second
-	_defaultBackoffFactor       = 2.0
+	_defaultMessageQueueCap  int = 1_000
+	_defaultBackoffMin           = 100 * time.Millisecond
+	_defaultBackoffMax           = 30 * time.Second
+	_defaultBackoffFactor        = 2.0
+	_defaultHandshakeTimeout     = 45 * time.Second
 )
 
 // Option defines websocket settings for New.
 type Option struct {
 	// Ping enables an automatic ping goroutine to keep the connection alive.
 	Ping bool
+	// Heartbeat defines the interval, timeout and payload of the ping goroutine.
+	Heartbeat HeartbeatOption
 	// BackoffOption defines reconnection backoff behavior.
 	Backoff BackoffOption
+	// Dialer defines the dialer configuration of every connecting attempt.
+	Dialer DialOption
+	// WriteQueue defines the outbound queue which buffers writes during reconnection.
+	WriteQueue WriteQueueOption
+	// Router extracts the routing key from every message for SubscribeTopic.
+	//
+	// Nil means topic subscribers receive nothing.
+	Router Router
+	// Correlation defines how Call assigns request ids and matches responses.
+	Correlation Correlation
+	// OnEvent is invoked synchronously with every connection lifecycle event.
+	//
+	// It is invoked outside of the internal locks, calling Close or Events inside it is allowed.
+	OnEvent func(Event)
+	// Recorder captures all inbound and outbound messages of every connection.
+	Recorder *Recorder
+	// Register defines how registers are replayed after every connecting/reconnecting.
+	Register RegisterOption
+	// Metrics receives the measurements of every connection, e.g. NewExpvarMetrics.
+	//
+	// Nil means no measurement.
+	Metrics Metrics
+	// RateLimit defines the token buckets of outbound messages per MessageClass.
+	//
+	// Zero means no limit.
+	RateLimit RateLimitOption
+	// Sequencer checks the sequence numbers of messages, reporting gaps and reordering frames before delivering.
+	Sequencer SequencerOption
+	// Trace defines the tracing of every connection, e.g. writing frames into a file with NewJSONTracer.
+	Trace TraceOption
+	// PooledBuffers reads frames into pooled buffers instead of allocating a slice per frame.
+	//
+	// Every Message delivered to a subscriber holds a reference to its buffer,
+	// call Message.Release once done with it so the buffer is reused, and Message.Retain to keep it longer.
+	// Messages passed to Sidecar.Waiter are released after it returns.
+	PooledBuffers bool
+	// BindSignal closes the websocket when the process receives SIGINT or SIGTERM.
+	//
+	// By default, the lifecycle is driven only by the context and Close,
+	// and no signal handler is installed.
+	BindSignal bool
 }
 
 // BackoffOption defines reconnection backoff behavior.
@@ -36,13 +86,90 @@ type BackoffOption struct {
 	Max time.Duration
 	// Factor is the multiplier for exponential backoff growth.
 	Factor float64
+	// Jitter randomizes the delay to avoid reconnecting in lockstep.
+	Jitter Jitter
+	// MaxAttempts is the maximum number of consecutive reconnect attempts.
+	//
+	// Zero means retrying forever.
+	MaxAttempts int
+	// MaxElapsed is the total retry budget since the connection becomes unavailable.
+	//
+	// Zero means retrying forever.
+	MaxElapsed time.Duration
+	// Policy overrides the built-in exponential backoff with a custom policy.
+	//
+	// Min, Max, Factor, Jitter, MaxAttempts and MaxElapsed are ignored when Policy is set.
+	Policy Backoff
+}
+
+// Backoff computes the delay before every reconnect attempt.
+type Backoff interface {
+	// Next returns the delay before the next attempt, returns false to stop reconnecting.
+	Next() (time.Duration, bool)
+	// Reset is invoked after the connection becomes available.
+	Reset()
+}
+
+// Jitter represents the randomization strategy of backoff delay.
+//
+// See https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+type Jitter int
+
+const (
+	// JitterNone uses the exponential delay without randomization.
+	JitterNone Jitter = iota
+	// JitterFull picks a random delay between 0 and the exponential delay.
+	JitterFull
+	// JitterEqual keeps half of the exponential delay and randomizes the other half.
+	JitterEqual
+	// JitterDecorrelated picks a random delay between Min and three times the previous delay.
+	JitterDecorrelated
+)
+
+// DialOption defines the dialer configuration of websocket connection.
+type DialOption struct {
+	// Header is sent with every handshake request.
+	Header http.Header
+	// HeaderProvider is invoked before every connecting attempt, the returned header overrides Header.
+	//
+	// It is useful for refreshing the authentication token on reconnection.
+	HeaderProvider func(context.Context) (http.Header, error)
+	// TLSConfig specifies the TLS configuration to use with tls.Client.
+	TLSConfig *tls.Config
+	// Proxy specifies a function to return a proxy for a given request.
+	//
+	// Nil means using http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+	// HandshakeTimeout specifies the duration for the handshake to complete.
+	//
+	// Zero means using the default timeout 45s.
+	HandshakeTimeout time.Duration
+	// ReadLimit is the maximum size in bytes for a message read from the peer.
+	//
+	// Zero means no limit.
+	ReadLimit int64
+	// EnableCompression negotiates per message compression (RFC 7692) with the server.
+	EnableCompression bool
+	// ReadBufferSize specifies the I/O read buffer size in bytes.
+	ReadBufferSize int
+	// WriteBufferSize specifies the I/O write buffer size in bytes.
+	WriteBufferSize int
+	// Subprotocols specifies the client's requested subprotocols.
+	Subprotocols []string
 }
 
 type backoffState struct {
-	min     time.Duration
-	max     time.Duration
-	factor  float64
-	current time.Duration
+	min         time.Duration
+	max         time.Duration
+	factor      float64
+	jitter      Jitter
+	maxAttempts int
+	maxElapsed  time.Duration
+
+	current  time.Duration
+	delay    time.Duration
+	attempts int
+	since    time.Time
 }
 
 // WebSocket holds a websocket connection and treat it like a producer.
@@ -57,13 +184,33 @@ type WebSocket struct {
 	dial      func() (*dialing, error)
 	shutdown  chan struct{}
 	reconnect chan struct{}
+	outbound  chan *outbound
+	direct    chan *outbound
+	writable  chan struct{}
+	writer    chan struct{}
 
 	registersLock sync.RWMutex
-	registers     []Sidecar
+	registers     []register
+
+	fanout    *fanout
+	sequencer *sequencer
+	limiters  limiters
+	nextID    atomic.Uint64
+
+	calls      map[string]pendingCall
+	callsLock  sync.Mutex
+	nextCallID atomic.Uint64
+
+	state      atomic.Int32
+	online     atomic.Bool
+	ready      atomic.Bool // ready is set once the websocket is ready, later connections are reconnections
+	queued     pendingCount
+	draining   atomic.Bool
+	peerClose  atomics.Value[*CloseError]
+	events     map[uint64]chan Event
+	eventsLock sync.Mutex
 
-	subscribers     map[uint64]chan Message
-	subscribersLock sync.RWMutex
-	nextID          atomic.Uint64
+	failure atomics.Value[error]
 
 	start  atomic.Bool
 	end    atomic.Bool
@@ -79,9 +226,15 @@ type Sidecar struct {
 	Waiter func(context.Context, Message) (isExpected bool, failure error)
 	// Timeout limits how long SendAndWait waits for an expected response.
 	Timeout time.Duration
+	// Name identifies the register, a register with the same name replaces the existing one.
+	//
+	// Empty means an anonymous register which is never replaced.
+	Name string
 }
 
 // New creates a new websocket connection without connecting to the websocket.
+//
+// The websocket is closed as Close does when ctx is done.
 func New(ctx context.Context, url string, opts ...Option) *WebSocket {
 	option := Option{}
 	if len(opts) > 0 {
@@ -92,22 +245,47 @@ func New(ctx context.Context, url string, opts ...Option) *WebSocket {
 	ws := &WebSocket{
 		url: url,
 		dial: func() (*dialing, error) {
-			return dial(ctx, url, option.Ping)
+			return dial(ctx, url, option)
 		},
-		shutdown:    make(chan struct{}),
-		reconnect:   make(chan struct{}, 1),
-		subscribers: make(map[uint64]chan Message),
-		option:      option,
+		shutdown:  make(chan struct{}),
+		reconnect: make(chan struct{}, 1),
+		outbound:  make(chan *outbound, option.WriteQueue.Cap),
+		direct:    make(chan *outbound),
+		writable:  make(chan struct{}, 1),
+		writer:    make(chan struct{}),
+		calls:     make(map[string]pendingCall),
+		queued:    pendingCount{idle: make(chan struct{}, 1)},
+		events:    make(map[uint64]chan Event),
+		option:    option,
 		logger: logs.Get(ctx).With(
 			"websocket", newLogID(),
 			"url", url,
 		),
 	}
+	ws.fanout = newFanout(option.Router, option.Metrics, ws.logger)
+	ws.sequencer = newSequencer(option.Sequencer)
+	ws.limiters = newLimiters(option.RateLimit)
+
+	context.AfterFunc(ctx, ws.Close)
+	if option.BindSignal {
+		go bindSignal(sys.Shutdown(), ws.shutdown, ws.Close)
+	}
 
 	return ws
 }
 
+// bindSignal invokes stop when signal is closed by the process receiving SIGINT or SIGTERM before done is closed.
+func bindSignal(signal <-chan os.Signal, done <-chan struct{}, stop func()) {
+	select {
+	case <-signal:
+		stop()
+	case <-done:
+	}
+}
+
 // ReadMessage parses the message with provided types
+//
+// Use SubscribeTyped to decode every message once for all subscribers and receive the decode errors.
 func ReadMessage[T any](msg Message) (T, bool) {
 	var resp T
 	err := json.Unmarshal(msg.Data, &resp)
@@ -136,9 +314,23 @@ func (ws *WebSocket) SendAndWait(ctx context.Context, executor Sidecar, appendIn
 	}
 
 	if len(appendIntoRegister) != 0 && appendIntoRegister[0] {
-		ws.registersLock.Lock()
-		ws.registers = append(ws.registers, executor)
-		ws.registersLock.Unlock()
+		ws.AddRegister(executor)
+	}
+
+	return ws.sendAndWait(ctx, executor, MessageClassRequest)
+}
+
+func (ws *WebSocket) sendAndWait(ctx context.Context, executor Sidecar, class MessageClass) error {
+	start := time.Now()
+	err := ws.runSidecar(ctx, executor, class)
+	ws.option.Metrics.SendAndWait(time.Since(start), err)
+
+	return err
+}
+
+func (ws *WebSocket) runSidecar(ctx context.Context, executor Sidecar, class MessageClass) error {
+	if err := ws.limit(ctx, class); err != nil {
+		return errors.Wrapf(err, "limit %s sender", class)
 	}
 
 	done := make(chan error, 1)
@@ -165,6 +357,7 @@ func (ws *WebSocket) SendAndWait(ctx context.Context, executor Sidecar, appendIn
 				}
 
 				ok, err := executor.Waiter(ctx, msg)
+				msg.Release()
 				if err != nil {
 					channel.TryPush(done, error(errors.Wrap(err, "waiting for message")))
 					return
@@ -177,9 +370,6 @@ func (ws *WebSocket) SendAndWait(ctx context.Context, executor Sidecar, appendIn
 			case <-ctx.Done():
 				channel.TryPush(done, ctx.Err())
 				return
-			case <-sys.Shutdown():
-				channel.TryPush(done, context.Canceled)
-				return
 			}
 		}
 	}()
@@ -195,6 +385,27 @@ func (ws *WebSocket) SendAndWait(ctx context.Context, executor Sidecar, appendIn
 	return nil
 }
 
+// deliver passes msg through the sequencer, then resolves the calls and broadcasts to subscribers.
+func (ws *WebSocket) deliver(msg Message) {
+	for _, msg := range ws.sequencer.push(msg) {
+		ws.resolveCall(msg)
+		ws.broadcast(msg)
+		msg.Release()
+	}
+}
+
+// disconnect reports the lost connection and schedules reconnecting.
+func (ws *WebSocket) disconnect(d *dialing) {
+	err := d.Err()
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		ws.peerClose.Store(ce)
+	}
+
+	ws.emit(Event{Type: EventDisconnected, Err: err})
+	channel.TryPush(ws.reconnect, struct{}{})
+}
+
 func (ws *WebSocket) clearConnection() {
 	var d *dialing
 	ws.conn.Store(d)
@@ -202,6 +413,21 @@ func (ws *WebSocket) clearConnection() {
 
 func normalizeOption(option Option) Option {
 	option.Backoff = normalizeBackoff(option.Backoff)
+	option.Dialer = normalizeDialer(option.Dialer)
+	option.WriteQueue = normalizeWriteQueue(option.WriteQueue)
+	option.Correlation = normalizeCorrelation(option.Correlation)
+	option.Metrics = normalizeMetrics(option.Metrics)
+	return option
+}
+
+func normalizeDialer(option DialOption) DialOption {
+	if option.Proxy == nil {
+		option.Proxy = http.ProxyFromEnvironment
+	}
+	if option.HandshakeTimeout <= 0 {
+		option.HandshakeTimeout = _defaultHandshakeTimeout
+	}
+
 	return option
 }
 
@@ -222,37 +448,93 @@ func normalizeBackoff(option BackoffOption) BackoffOption {
 	return option
 }
 
-func newBackoffState(option BackoffOption) backoffState {
+func newBackoffState(option BackoffOption) *backoffState {
 	option = normalizeBackoff(option)
-	return backoffState{
-		min:    option.Min,
-		max:    option.Max,
-		factor: option.Factor,
+	return &backoffState{
+		min:         option.Min,
+		max:         option.Max,
+		factor:      option.Factor,
+		jitter:      option.Jitter,
+		maxAttempts: option.MaxAttempts,
+		maxElapsed:  option.MaxElapsed,
+	}
+}
+
+func newBackoff(option BackoffOption) Backoff {
+	if option.Policy != nil {
+		return option.Policy
 	}
+
+	return newBackoffState(option)
 }
 
-func (b *backoffState) Next() time.Duration {
+func (b *backoffState) Next() (time.Duration, bool) {
+	if b.attempts == 0 {
+		b.since = time.Now()
+	}
+	b.attempts++
+
+	if b.maxAttempts > 0 && b.attempts > b.maxAttempts {
+		return 0, false
+	}
+	if b.maxElapsed > 0 && time.Since(b.since) >= b.maxElapsed {
+		return 0, false
+	}
+
 	if b.min <= 0 {
-		return 0
+		return 0, true
 	}
+
 	if b.current <= 0 {
 		b.current = b.min
-		return b.current
+	} else {
+		next := time.Duration(float64(b.current) * b.factor)
+		if next < b.min {
+			next = b.min
+		}
+		if b.max > 0 && next > b.max {
+			next = b.max
+		}
+		b.current = next
 	}
 
-	next := time.Duration(float64(b.current) * b.factor)
-	if next < b.min {
-		next = b.min
+	switch b.jitter {
+	case JitterFull:
+		b.delay = randDuration(0, b.current)
+	case JitterEqual:
+		b.delay = b.current/2 + randDuration(0, b.current/2)
+	case JitterDecorrelated:
+		prev := b.delay
+		if prev < b.min {
+			prev = b.min
+		}
+		b.delay = min(b.max, randDuration(b.min, prev*3))
+	default:
+		b.delay = b.current
 	}
-	if b.max > 0 && next > b.max {
-		next = b.max
+
+	if b.maxElapsed > 0 {
+		if remain := b.maxElapsed - time.Since(b.since); b.delay > remain {
+			b.delay = max(remain, 0)
+		}
 	}
-	b.current = next
-	return b.current
+
+	return b.delay, true
 }
 
 func (b *backoffState) Reset() {
 	b.current = 0
+	b.delay = 0
+	b.attempts = 0
+}
+
+// randDuration returns a random duration in [lo, hi].
+func randDuration(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+
+	return lo + rand.N(hi-lo+1)
 }
 
 func (ws *WebSocket) waitBackoff(ctx context.Context, delay time.Duration) bool {
@@ -264,8 +546,6 @@ func (ws *WebSocket) waitBackoff(ctx context.Context, delay time.Duration) bool
 	defer timer.Stop()
 
 	select {
-	case <-sys.Shutdown():
-		return false
 	case <-ctx.Done():
 		return false
 	case <-ws.shutdown:
@@ -276,20 +556,36 @@ func (ws *WebSocket) waitBackoff(ctx context.Context, delay time.Duration) bool
 }
 
 func (ws *WebSocket) observeReconnection(ctx context.Context, url string, start chan struct{}) {
+	// every return is terminal, e.g. ctx is done, so the websocket is closed as Close does
+	defer ws.Close()
+
 	var once sync.Once
-	backoff := newBackoffState(ws.option.Backoff)
+	backoff := newBackoff(ws.option.Backoff)
+	attempt := 0
 
-loop:
 	for {
 		select {
-		case <-sys.Shutdown():
-			return
 		case <-ctx.Done():
 			return
 		case <-ws.shutdown:
 			return
 		case <-ws.reconnect:
-			dur := backoff.Next()
+			if ws.draining.Load() {
+				continue
+			}
+
+			attempt++
+			dur, ok := backoff.Next()
+			if !ok {
+				err := errors.Wrapf(ErrReconnectExhausted, "after %d attempts", attempt-1)
+				ws.failure.Store(err)
+				ws.emit(Event{Type: EventGaveUp, Err: err, Attempt: attempt - 1})
+				ws.logger.Errorf("stop reconnecting, err: %+v", err)
+				ws.Close()
+				return
+			}
+
+			ws.emit(Event{Type: EventBackoffScheduled, Delay: dur, Attempt: attempt})
 			ws.logger.Warnf("reconnecting in %s...", dur)
 			if !ws.waitBackoff(ctx, dur) {
 				return
@@ -297,31 +593,34 @@ loop:
 
 			ws.logger.Warn("reconnecting...")
 			ws.clearConnection()
+			ws.emit(Event{Type: EventConnecting, Attempt: attempt})
 			d, err := ws.dial()
 			if err != nil {
+				ws.emit(Event{Type: EventDialFailed, Err: err, Attempt: attempt})
 				ws.logger.Errorf("ws connect to (%s), err: %+v", url, err)
 				channel.TryPush(ws.reconnect, struct{}{})
 				continue
 			}
 
+			for _, msg := range ws.sequencer.reconnect() {
+				ws.broadcast(msg)
+				msg.Release()
+			}
+
 			go func() {
 				defer d.Close()
+				defer ws.failCalls(d, ErrConnectionClose)
 				for {
 					select {
-					case <-sys.Shutdown():
-						return
 					case <-ctx.Done():
 						return
 					case <-ws.shutdown:
 						return
-					case <-d.Done():
-						channel.TryPush(ws.reconnect, struct{}{})
-						return
 					case msg, ok := <-d.Message():
 						if ok {
-							ws.broadcast(msg)
+							ws.deliver(msg)
 						} else {
-							channel.TryPush(ws.reconnect, struct{}{})
+							ws.disconnect(d)
 							return
 						}
 					}
@@ -330,37 +629,32 @@ loop:
 
 			ws.logger.Infof("ws connect to (%s) succeed, start subscribing...", url)
 			ws.conn.Store(d)
+			ws.emit(Event{Type: EventConnected, Attempt: attempt})
 
-			ws.registersLock.RLock()
-			for _, register := range ws.registers {
-				if err := ws.SendAndWait(ctx, register); err != nil {
-					ws.clearConnection()
-					ws.logger.Errorf("register, err: %+v", err)
-					channel.TryPush(ws.reconnect, struct{}{})
-					continue loop
-				}
+			if err := ws.replayRegisters(ctx); err != nil {
+				ws.clearConnection()
+				d.Close()
+				ws.emit(Event{Type: EventRegisterFailed, Err: err, Attempt: attempt})
+				ws.logger.Errorf("register, err: %+v", err)
+				channel.TryPush(ws.reconnect, struct{}{})
+				continue
 			}
-			ws.registersLock.RUnlock()
 
 			once.Do(func() {
 				channel.SafeClose(start)
 			})
 
 			backoff.Reset()
+			ws.emit(Event{Type: EventRegistered, Attempt: attempt})
+			channel.TryPush(ws.writable, struct{}{})
+			attempt = 0
 			ws.logger.Info("ws subscribing succeed, connection available")
 		}
 	}
 }
 
 func (ws *WebSocket) broadcast(msg Message) {
-	ws.subscribersLock.RLock()
-	defer ws.subscribersLock.RUnlock()
-
-	for _, ch := range ws.subscribers {
-		if !channel.TryPush(ch, msg) {
-			ws.logger.Warnf("broadcast message dropped for a subscriber, channel is full or closed")
-		}
-	}
+	ws.fanout.broadcast(msg)
 }
 
 func (ws *WebSocket) getConn() *dialing {
@@ -374,6 +668,8 @@ func (ws *WebSocket) getConn() *dialing {
 
 // Start starts connecting to the websocket.
 //
+// ctx drives the lifecycle like the one of New, the websocket is closed as Close does when it is done.
+//
 // Args:
 //   - register: represents operations which must be invoked after every websocket connecting/reconnecting
 func (ws *WebSocket) Start(_ctx context.Context, registers ...Sidecar) error {
@@ -381,22 +677,32 @@ func (ws *WebSocket) Start(_ctx context.Context, registers ...Sidecar) error {
 		return nil
 	}
 
-	ws.registers = registers
+	ws.registersLock.Lock()
+	for _, register := range registers {
+		ws.addRegister(register)
+	}
+	ws.registersLock.Unlock()
 
 	start := make(chan struct{})
 	defer channel.SafeClose(start)
 
 	go ws.observeReconnection(_ctx, ws.url, start)
+	if ws.option.WriteQueue.Enable {
+		go ws.writeLoop(_ctx)
+	}
 
 	channel.TryPush(ws.reconnect, struct{}{})
 	ctx, cancel := context.WithTimeout(_ctx, DefaultStartTimeout)
 	defer cancel()
 
 	select {
-	case <-sys.Shutdown():
-		return errors.Wrap(context.Canceled)
 	case <-ctx.Done():
 		return errors.Wrap(ctx.Err(), "timeout")
+	case <-ws.shutdown:
+		if err := ws.Err(); err != nil {
+			return errors.Wrap(err, "start")
+		}
+		return errors.Wrap(ErrConnectionClose, "websocket closed")
 	case <-start:
 		ws.logger.Info("start ws succeed")
 	}
@@ -404,20 +710,26 @@ func (ws *WebSocket) Start(_ctx context.Context, registers ...Sidecar) error {
 	return nil
 }
 
+// Err returns the terminal error which stops the websocket from reconnecting
+func (ws *WebSocket) Err() error {
+	return ws.failure.Load()
+}
+
 // Close closes the websocket connection
 func (ws *WebSocket) Close() {
 	if ws.end.Swap(true) {
 		return
 	}
 
+	// the state is closed before shutdown, so whoever observes shutdown, e.g. Start, observes StateClosed
+	e := Event{Type: EventClosed, Time: time.Now()}
+	onEvent := ws.dispatch(e)
 	channel.SafeClose(ws.shutdown)
-
-	ws.subscribersLock.Lock()
-	defer ws.subscribersLock.Unlock()
-	for id, ch := range ws.subscribers {
-		channel.SafeClose(ch)
-		delete(ws.subscribers, id)
+	ws.failCalls(nil, ErrConnectionClose)
+	if onEvent != nil {
+		onEvent(e)
 	}
+	ws.fanout.close()
 }
 
 // IsClose returns whether the websocket is closed or not
@@ -436,51 +748,77 @@ func (ws *WebSocket) Reconnect() {
 }
 
 // Subscribe subscribes the websocket message producer
-func (ws *WebSocket) Subscribe() (<-chan Message, func()) {
-	ws.subscribersLock.Lock()
-	defer ws.subscribersLock.Unlock()
-
-	id := ws.nextID.Add(1)
-	ch := make(chan Message, _defaultMessageQueueCap)
-	ws.subscribers[id] = ch
+//
+// The optional SubscribeOption defines the queue capacity and overflow policy of the subscription.
+func (ws *WebSocket) Subscribe(opts ...SubscribeOption) (<-chan Message, func()) {
+	option := SubscribeOption{}
+	if len(opts) > 0 {
+		option = opts[0]
+	}
 
-	unsubscribe := func() {
-		ws.subscribersLock.Lock()
-		defer ws.subscribersLock.Unlock()
+	return ws.fanout.subscribe(option)
+}
 
-		if ch, ok := ws.subscribers[id]; ok {
-			channel.SafeClose(ch)
-			delete(ws.subscribers, id)
-		}
+// SubscribeTopic subscribes the websocket messages whose routing key extracted by Option.Router equals key
+//
+// The optional SubscribeOption defines the queue capacity and overflow policy of the subscription.
+func (ws *WebSocket) SubscribeTopic(key string, opts ...SubscribeOption) (<-chan Message, func()) {
+	option := SubscribeOption{}
+	if len(opts) > 0 {
+		option = opts[0]
 	}
 
-	return ch, unsubscribe
+	return ws.fanout.subscribeTopic(key, option)
+}
+
+// Dropped returns the number of messages dropped by the overflow policies of all subscriptions
+func (ws *WebSocket) Dropped() uint64 {
+	return ws.fanout.dropped.Load()
 }
 
 // WriteJSON writes a JSON message to the websocket connection
+//
+// With Option.WriteQueue enabled, the message is queued and written by the writer goroutine,
+// use WriteMessage to wait for the delivery.
 func (ws *WebSocket) WriteJSON(v any) error {
+	if ws.option.WriteQueue.Enable {
+		return ws.enqueueJSON(v)
+	}
+
 	d := ws.getConn()
 	if d == nil {
 		return errors.Wrap(ErrConnectionClose, "nil ws connection")
 	}
 
+	if err := ws.limit(context.Background(), MessageClassWrite); err != nil {
+		return errors.Wrap(err, "write json")
+	}
+
 	return d.WriteJSON(v)
 }
 
 // WriteRaw writes a raw message to the websocket connection
+//
+// With Option.WriteQueue enabled, data is copied, queued and written by the writer goroutine,
+// use WriteMessage to wait for the delivery.
 func (ws *WebSocket) WriteRaw(messageType MessageType, data []byte, subscribeFunc ...bool) error {
+	if ws.option.WriteQueue.Enable {
+		return ws.enqueueRaw(messageType, data)
+	}
+
 	d := ws.getConn()
 	if d == nil {
 		return errors.Wrap(ErrConnectionClose, "nil ws connection")
 	}
 
+	if err := ws.limit(context.Background(), MessageClassWrite); err != nil {
+		return errors.Wrap(err, "write raw")
+	}
+
 	return d.WriteRaw(messageType, data)
 }
 
 // Len returns the subscribers number of websocket connection
 func (ws *WebSocket) Len() int {
-	ws.subscribersLock.RLock()
-	defer ws.subscribersLock.RUnlock()
-
-	return len(ws.subscribers)
+	return ws.fanout.len()
 }